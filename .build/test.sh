@@ -5,7 +5,7 @@ echo "" > coverage.txt
 
 # aggregate coverage from multiple packages
 for d in $(go list ./... | grep -v vendor); do
-    go test -v -race -coverprofile=profile.out -covermode=atomic $d
+    go test -v -race -tags nats -coverprofile=profile.out -covermode=atomic $d
     if [ -f profile.out ]; then
         cat profile.out >> coverage.txt
         rm profile.out
@@ -0,0 +1,65 @@
+package component
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ComponentError captures the failure of a lifecycle hook of a component.
+type ComponentError struct {
+	Group     string
+	Component string
+	Phase     Phase
+	Err       error
+}
+
+func (e *ComponentError) Error() string {
+	return fmt.Sprintf("%s: %s failed to %s: %v", e.Group, e.Component, e.Phase, e.Err)
+}
+
+// Unwrap returns the error returned by the lifecycle hook.
+func (e *ComponentError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError captures all the failures of a lifecycle phase across the components
+// and the child groups of a group. Failures of lifecycle hooks are captured as
+// ComponentError entries.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	if len(m) == 1 {
+		return m[0].Error()
+	}
+	msgs := make([]string, 0, len(m))
+	for _, e := range m {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(m), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the aggregated errors so that they can be inspected with
+// errors.Is and errors.As.
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+// append adds the errors to the multi error, flattening any nested multi errors.
+func (m MultiError) append(errs ...error) MultiError {
+	for _, e := range errs {
+		if me, ok := e.(MultiError); ok {
+			m = m.append(me...)
+		} else if e != nil {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+// errorOrNil returns nil if no errors are captured, else the multi error.
+func (m MultiError) errorOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -3,10 +3,11 @@ package component
 import (
 	"context"
 	"flag"
-	"fmt"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/anuvu/cube/config"
 	"github.com/anuvu/cube/di"
@@ -36,6 +37,7 @@ type StopHook interface {
 }
 
 // HealthHook is the interface that provides the health callback for the component.
+// See HealthCheckHook for reporting the detailed health of the component.
 type HealthHook interface {
 	IsHealthy(ctx Context) bool
 }
@@ -54,21 +56,35 @@ type Group interface {
 	Start() error
 	Stop() error
 	IsHealthy() bool
+	Health() *HealthReport
+	State() State
+	Subscribe(f func(Transition))
+	SubscribeHooks(f func(HookEvent))
 }
 
 // Group is a group of components, that have inter-dependencies.
 type group struct {
-	name        string
-	parent      *group
-	children    map[string]*group
-	store       config.Store
-	cli         *flag.FlagSet
-	c           *di.Container
-	ctx         *srvCtx
-	configHooks []ConfigHook
-	startHooks  []StartHook
-	stopHooks   []StopHook
-	healthHooks []HealthHook
+	name          string
+	parent        *group
+	children      map[string]*group
+	store         config.Store
+	cli           *flag.FlagSet
+	c             *di.Container
+	ctx           *srvCtx
+	timeouts      *Timeouts
+	configHooks   []ConfigHook
+	startHooks    map[reflect.Type]StartHook
+	stopHooks     map[reflect.Type]StopHook
+	healthHooks   map[reflect.Type]componentHealth
+	stateLock     sync.Mutex
+	state         State
+	busy          bool
+	phase         Phase
+	phaseStart    time.Time
+	observers     []func(Transition)
+	hookObservers []func(HookEvent)
+	started       map[reflect.Type]bool
+	monitor       *HealthMonitor
 }
 
 var ctxType = reflect.TypeOf((*Context)(nil)).Elem()
@@ -77,18 +93,35 @@ var shutType = reflect.TypeOf((*Shutdown)(nil)).Elem()
 // New creates a new component group with the specified parent. If the parent is nil
 // this group is the root group.
 func New(name string) Group {
+	return newRoot(name, DefaultTimeouts)
+}
+
+// newRoot creates a root group with the lifecycle deadlines.
+func newRoot(name string, timeouts Timeouts) *group {
 	grp := newGroup(name, nil)
 
 	// Root container should provide the server shutdown function
 	shut := ServerShutdown(grp.ctx.cancelFunc)
 	grp.c.Add(func() ServerShutdown { return shut })
 
+	// Root container should provide the root group for components reporting
+	// on the whole group hierarchy
+	grp.c.Add(func() Group { return grp })
+
 	// Root container should provide cli
 	grp.cli = flag.NewFlagSet(name, flag.ContinueOnError)
 	grp.c.Add(func() *flag.FlagSet { return grp.cli })
 
-	// Create the store
+	// Create the store, root container provides it for components loading
+	// multiple configuration objects. The store is open only while the groups
+	// are being configured.
 	grp.store = newConfigStore(grp.cli)
+	grp.c.Add(func() config.Store { return grp.store })
+
+	// Root group owns the lifecycle deadlines shared by all the groups
+	for p, d := range timeouts {
+		(*grp.timeouts)[p] = d
+	}
 	return grp
 }
 
@@ -97,11 +130,13 @@ func newGroup(name string, parent *group) *group {
 	var pctx *srvCtx
 	var cli *flag.FlagSet
 	var store config.Store
+	timeouts := &Timeouts{}
 	if parent != nil {
 		pc = parent.c
 		pctx = parent.ctx
 		cli = parent.cli
 		store = parent.store
+		timeouts = parent.timeouts
 	}
 
 	log := zlog.New(name)
@@ -115,10 +150,12 @@ func newGroup(name string, parent *group) *group {
 		cli:         cli,
 		c:           c,
 		ctx:         ctx,
+		timeouts:    timeouts,
 		configHooks: []ConfigHook{},
-		startHooks:  []StartHook{},
-		stopHooks:   []StopHook{},
-		healthHooks: []HealthHook{},
+		startHooks:  map[reflect.Type]StartHook{},
+		stopHooks:   map[reflect.Type]StopHook{},
+		healthHooks: map[reflect.Type]componentHealth{},
+		started:     map[reflect.Type]bool{},
 	}
 
 	// Provide the Context, Shutdown per group
@@ -148,16 +185,25 @@ func (g *group) Invoke(f interface{}) error {
 	return g.c.Invoke(f, nil)
 }
 
+// Create constructs the components of the group and all the child groups.
 func (g *group) Create() error {
+	if err := g.enter(PhaseCreate); err != nil {
+		return err
+	}
+	return g.exit(StateCreated, g.create())
+}
+
+func (g *group) create() error {
 	g.ctx.Log().Info().Msg("creating group")
-	// g.c.Create will call this function for each value produced by ctr
+	// g.c.CreateWith will call this function for each value produced by ctr
 	// constructor method we then check if the produced value implements
 	// any of the lifecycle hooks and cache them so that we can invoke them
-	// as part of the server lifecycle.
+	// as part of the server lifecycle. Each constructor has to complete
+	// within the create deadline.
 	vf := func(v reflect.Value) error {
 		return g.addLCHooks(v)
 	}
-	if err := g.c.Create(vf); err != nil {
+	if err := g.c.CreateWith(vf, g.createWithDeadline); err != nil {
 		return err
 	}
 
@@ -171,6 +217,13 @@ func (g *group) Create() error {
 
 // Configure calls the configure hooks on all components registered for configuration.
 func (g *group) Configure() error {
+	if err := g.enter(PhaseConfigure); err != nil {
+		return err
+	}
+	return g.exit(StateConfigured, g.configure())
+}
+
+func (g *group) configure() error {
 	if g.parent == nil {
 		// root group parse the cli and initialize the config store
 		if err := g.cli.Parse(os.Args[1:]); err != nil {
@@ -180,6 +233,16 @@ func (g *group) Configure() error {
 			return err
 		}
 		defer g.store.Close()
+
+		// Load the lifecycle deadlines shared by all the groups
+		lc := &lifecycleConfig{config.BaseConfig{ConfigKey: "lifecycle"}, nil}
+		if err := g.store.Get(lc); err == nil {
+			for p, d := range lc.Timeouts {
+				(*g.timeouts)[p] = d
+			}
+		} else if _, ok := err.(*config.NotFoundError); !ok {
+			return err
+		}
 	}
 
 	g.ctx.Log().Info().Msg("configuring group")
@@ -188,8 +251,8 @@ func (g *group) Configure() error {
 		if err := g.store.Get(cfg); err != nil {
 			return err
 		}
-		if err := h.Configure(g.ctx); err != nil {
-			return err
+		if err := g.withDeadline(PhaseConfigure, h, func() error { return h.Configure(g.ctx) }); err != nil {
+			return g.hookError(h, PhaseConfigure, err)
 		}
 	}
 
@@ -203,67 +266,136 @@ func (g *group) Configure() error {
 }
 
 // Start calls the start hooks on all components registered for startup.
-// If an error occurs on any hook, subsequent start calls are abandoned
-// and a best effort stop is initiated.
+// Each hook has to complete within the start deadline of the component, else
+// it is abandoned and the start fails with a TimeoutError.
+// The hooks are called in the dependency order of the components, components
+// that do not depend on each other are started concurrently. Child groups are
+// started concurrently once all the components of this group are started.
+//
+// Start is transactional across the group hierarchy. If an error occurs on any
+// hook, start calls on the components depending on it are abandoned and every
+// component that is already started in the hierarchy, or whose start hook
+// failed or was abandoned, is stopped in the reverse order. The components
+// whose start failed are stopped on a best effort basis to release what they
+// may have acquired. The returned MultiError captures
+// the start failures along with any failures of the rollback.
 func (g *group) Start() error {
+	if err := g.enter(PhaseStart); err != nil {
+		return err
+	}
+
+	errs := g.start()
+	if len(errs) > 0 {
+		// Roll back all the components that are started in the hierarchy
+		errs = errs.append(g.stop(true))
+	}
+	return errs.errorOrNil()
+}
+
+// start calls the start hooks of the group and starts the child groups. The
+// group must have entered the start phase.
+func (g *group) start() MultiError {
 	g.ctx.Log().Info().Msg("starting group")
-	for _, h := range g.startHooks {
-		if err := g.c.Invoke(h.Start, nil); err != nil {
-			// We need to call all stop hooks and ignore errors
-			// as we dont know which components are actually participating
-			// in the stop callbacks
-			defer g.Stop()
-			return err
+	errs := MultiError{}.append(schedule(g.c.Dependencies(), false, func(t reflect.Type) error {
+		h, ok := g.startHooks[t]
+		if !ok {
+			return nil
 		}
-	}
+		err := g.withDeadline(PhaseStart, h, func() error { return g.c.Invoke(h.Start, nil) })
+		// A failed hook may have started part of the component and an
+		// abandoned one may still complete the start in the background, the
+		// component is stopped on rollback like the started ones
+		g.stateLock.Lock()
+		g.started[t] = true
+		g.stateLock.Unlock()
+		return g.hookError(h, PhaseStart, err)
+	})...)
 
 	// Start all the child groups
-	for _, child := range g.children {
-		if err := child.Start(); err != nil {
-			return err
-		}
+	if len(errs) == 0 {
+		errs = errs.append(g.eachChild(func(child *group) error {
+			if err := child.enter(PhaseStart); err != nil {
+				return err
+			}
+			return child.start().errorOrNil()
+		})...)
 	}
-	return nil
+	g.exit(StateStarted, errs.errorOrNil())
+	return errs
 }
 
 // Stop calls the stop hooks on all components registered for shutdown.
+// A group can be stopped in any state once it is created.
+// Hooks that do not complete within the stop deadline of the component are
+// abandoned.
+// Child groups are stopped concurrently before any of the components of
+// this group. The hooks are called in the reverse dependency order of the
+// components, components that do not depend on each other are stopped
+// concurrently. Every failure of the child groups and the hooks is captured
+// in the returned MultiError.
 func (g *group) Stop() error {
-	var e error
+	return g.stop(false)
+}
 
-	// Stop all the child groups first
-	for _, child := range g.children {
-		e = child.Stop()
+// stop stops the group and its child groups. If rollback is true only the
+// components that are started are stopped.
+func (g *group) stop(rollback bool) error {
+	if err := g.enter(PhaseStop); err != nil {
+		return err
 	}
 
+	// Stop all the child groups first, skipping the ones that have nothing to stop
+	errs := MultiError{}.append(g.eachChild(func(child *group) error {
+		if s := child.State(); s == StateNew || s == StateStopped {
+			return nil
+		}
+		return child.stop(rollback)
+	})...)
+
 	g.ctx.Log().Info().Msg("stopping group")
 
 	// Invoke the stop hooks in the reverse dependency order
-	if len(g.stopHooks) > 0 {
-		for i := len(g.stopHooks) - 1; i == 0; i-- {
-			h := g.stopHooks[i]
-			if err := g.c.Invoke(h.Stop, nil); err != nil {
-				// FIXME: We need to make this multi-error
-				e = err
-			}
+	g.stateLock.Lock()
+	started := g.started
+	g.started = map[reflect.Type]bool{}
+	g.stateLock.Unlock()
+	errs = errs.append(schedule(g.c.Dependencies(), true, func(t reflect.Type) error {
+		h, ok := g.stopHooks[t]
+		if !ok || (rollback && !started[t]) {
+			return nil
 		}
-	}
-	return e
+		return g.hookError(h, PhaseStop, g.withDeadline(PhaseStop, h, func() error { return g.c.Invoke(h.Stop, nil) }))
+	})...)
+	return g.exit(StateStopped, errs.errorOrNil())
 }
 
-// IsHealthy returns true if all components health hooks return true else false
-func (g *group) IsHealthy() bool {
-	for _, h := range g.healthHooks {
-		if !h.IsHealthy(g.ctx) {
-			return false
-		}
+// eachChild calls f on all the child groups concurrently and returns the
+// errors returned by f.
+func (g *group) eachChild(f func(*group) error) []error {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	errs := []error{}
+	for _, child := range g.children {
+		wg.Add(1)
+		go func(child *group) {
+			defer wg.Done()
+			if err := f(child); err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+			}
+		}(child)
 	}
+	wg.Wait()
+	return errs
+}
 
-	for _, child := range g.children {
-		if !child.IsHealthy() {
-			return false
-		}
+// hookError captures the error returned by a lifecycle hook of the component.
+func (g *group) hookError(cmp interface{}, phase Phase, err error) error {
+	if err == nil {
+		return nil
 	}
-	return true
+	return &ComponentError{Group: g.path(), Component: reflect.TypeOf(cmp).String(), Phase: phase, Err: err}
 }
 
 // Add the lifecycle hooks to the group.
@@ -273,17 +405,24 @@ func (g *group) addLCHooks(v reflect.Value) error {
 		g.configHooks = append(g.configHooks, i)
 	}
 	if i, ok := val.(StartHook); ok {
-		g.startHooks = append(g.startHooks, i)
+		g.startHooks[valueType(v)] = i
 	}
 	if i, ok := val.(StopHook); ok {
-		g.stopHooks = append(g.stopHooks, i)
-	}
-	if i, ok := val.(HealthHook); ok {
-		g.healthHooks = append(g.healthHooks, i)
+		g.stopHooks[valueType(v)] = i
 	}
+	g.addHealthHook(v)
 	return nil
 }
 
+// valueType returns the type the value is tracked by in the container.
+func valueType(v reflect.Value) reflect.Type {
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
 func newConfigStore(cli *flag.FlagSet) config.Store {
 	s := &cfgStore{}
 	cli.StringVar(&s.fileCfg, "config.file", "", "file configuration store")
@@ -320,12 +459,12 @@ func (s *cfgStore) Close() {
 	}
 }
 
-func (s *cfgStore) Get(config config.Config) error {
-	if config == nil || config.Key().IsNil() {
+func (s *cfgStore) Get(cfg config.Config) error {
+	if cfg == nil || cfg.Key().IsNil() {
 		return nil
 	}
 	if s.store == nil {
-		return fmt.Errorf("%s key not found", config.Key())
+		return &config.NotFoundError{Key: cfg.Key()}
 	}
-	return s.store.Get(config)
+	return s.store.Get(cfg)
 }
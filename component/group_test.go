@@ -1,9 +1,12 @@
 package component
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/anuvu/cube/config"
 	. "github.com/smartystreets/goconvey/convey"
@@ -53,7 +56,7 @@ func (cmp *cmpWithHooks) IsHealthy(ctx Context) bool { return cmp.startCalled &&
 type cmpWithErrors cmp
 
 func newCmpWithErrors(ctx Context) *cmpWithErrors {
-	return &cmpWithErrors{}
+	return &cmpWithErrors{errorConfig: true}
 }
 
 func (cmp *cmpWithErrors) Config() config.Config {
@@ -63,7 +66,10 @@ func (cmp *cmpWithErrors) Config() config.Config {
 
 func (cmp *cmpWithErrors) Configure(ctx Context) error {
 	cmp.configureCalled = true
-	return fmt.Errorf("config error")
+	if cmp.errorConfig {
+		return fmt.Errorf("config error")
+	}
+	return nil
 }
 func (cmp *cmpWithErrors) Start(ctx Context) error {
 	cmp.startCalled = true
@@ -114,6 +120,7 @@ func TestGroup(t *testing.T) {
 					So(grp.IsHealthy(), ShouldBeFalse)
 				})
 				Convey("we should be able to start the group", func() {
+					So(grp.Configure(), ShouldBeNil)
 					So(grp.Start(), ShouldBeNil)
 					So(s.startCalled, ShouldBeTrue)
 					So(grp.IsHealthy(), ShouldBeTrue)
@@ -129,6 +136,9 @@ func TestGroup(t *testing.T) {
 		Convey("check component with errors", func() {
 			So(grp.Add(func(*cmpWithErrors) int { return 0 }), ShouldBeNil)
 			So(grp.Create(), ShouldBeError)
+			So(grp.State(), ShouldEqual, StateFailed)
+			So(grp.Create(), ShouldHaveSameTypeAs, &StateError{})
+			grp = New("base").(*group)
 			So(grp.Add(newCmpWithErrors), ShouldBeNil)
 			So(grp.Create(), ShouldBeNil)
 			So(grp.IsHealthy(), ShouldBeFalse)
@@ -139,6 +149,8 @@ func TestGroup(t *testing.T) {
 					So(grp.IsHealthy(), ShouldBeFalse)
 				})
 				Convey("start should be error", func() {
+					s.errorConfig = false
+					So(grp.Configure(), ShouldBeNil)
 					So(grp.Start(), ShouldNotBeNil)
 					So(s.startCalled, ShouldBeTrue)
 					So(s.stopCalled, ShouldBeTrue)
@@ -177,6 +189,7 @@ func TestGroupHierarchy(t *testing.T) {
 					So(root.IsHealthy(), ShouldBeFalse)
 				})
 				Convey("we should be able to start the group", func() {
+					So(root.Configure(), ShouldBeNil)
 					So(root.Start(), ShouldBeNil)
 					So(s.startCalled, ShouldBeTrue)
 					So(root.IsHealthy(), ShouldBeTrue)
@@ -200,6 +213,8 @@ func TestGroupHierarchy(t *testing.T) {
 					So(root.IsHealthy(), ShouldBeFalse)
 				})
 				Convey("start should be error", func() {
+					s.errorConfig = false
+					So(root.Configure(), ShouldBeNil)
 					So(root.Start(), ShouldNotBeNil)
 					So(s.startCalled, ShouldBeTrue)
 					So(s.stopCalled, ShouldBeTrue)
@@ -305,3 +320,462 @@ func TestBadCli(t *testing.T) {
 		So(grp.Configure(), ShouldBeError)
 	})
 }
+
+type lcEvents struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (e *lcEvents) add(ev string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.events = append(e.events, ev)
+}
+
+func (e *lcEvents) index(ev string) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for i, v := range e.events {
+		if v == ev {
+			return i
+		}
+	}
+	return -1
+}
+
+type slowCmp struct {
+	name   string
+	events *lcEvents
+}
+
+func (s *slowCmp) Start(ctx Context) error {
+	time.Sleep(100 * time.Millisecond)
+	s.events.add(s.name + ".start")
+	return nil
+}
+
+func (s *slowCmp) Stop(ctx Context) error {
+	time.Sleep(100 * time.Millisecond)
+	s.events.add(s.name + ".stop")
+	return nil
+}
+
+type slowA struct{ slowCmp }
+type slowB struct{ slowCmp }
+type slowC struct{ slowCmp }
+
+func TestGroupStartOrder(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"group.test"}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we create a group with slow components", t, func() {
+		grp := New("base").(*group)
+		So(grp.Add(func() *lcEvents { return &lcEvents{} }), ShouldBeNil)
+		So(grp.Add(func(e *lcEvents, c *slowA) *slowC { return &slowC{slowCmp{"c", e}} }), ShouldBeNil)
+		So(grp.Add(func(e *lcEvents) *slowA { return &slowA{slowCmp{"a", e}} }), ShouldBeNil)
+		So(grp.Add(func(e *lcEvents) *slowB { return &slowB{slowCmp{"b", e}} }), ShouldBeNil)
+		So(grp.Create(), ShouldBeNil)
+		So(grp.Configure(), ShouldBeNil)
+
+		grp.Invoke(func(events *lcEvents) {
+			Convey("independent components should start concurrently in dependency order", func() {
+				start := time.Now()
+				So(grp.Start(), ShouldBeNil)
+				So(time.Since(start), ShouldBeLessThan, 300*time.Millisecond)
+				So(events.index("a.start"), ShouldBeLessThan, events.index("c.start"))
+				So(events.index("b.start"), ShouldBeGreaterThanOrEqualTo, 0)
+
+				Convey("components should stop concurrently in reverse dependency order", func() {
+					start := time.Now()
+					So(grp.Stop(), ShouldBeNil)
+					So(time.Since(start), ShouldBeLessThan, 300*time.Millisecond)
+					So(events.index("c.stop"), ShouldBeLessThan, events.index("a.stop"))
+					So(events.index("b.stop"), ShouldBeGreaterThanOrEqualTo, 0)
+				})
+			})
+		})
+	})
+}
+
+type stuckCmp struct {
+	release chan struct{}
+}
+
+func (s *stuckCmp) Start(ctx Context) error {
+	<-s.release
+	return nil
+}
+
+func (s *stuckCmp) Stop(ctx Context) error {
+	<-s.release
+	return nil
+}
+
+func (s *stuckCmp) Timeouts() Timeouts {
+	return Timeouts{PhaseStop: 50 * time.Millisecond}
+}
+
+type blockingCmp struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingCmp) Start(ctx Context) error {
+	close(b.started)
+	<-b.release
+	return nil
+}
+
+func TestGroupTimeouts(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"group.test", "--config.mem", `{"lifecycle": {"start": "50ms", "stop": "1h"}}`}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we create a group with a stuck component", t, func() {
+		s := &stuckCmp{make(chan struct{})}
+		defer close(s.release)
+		grp := New("base").(*group)
+		So(grp.Add(func() *stuckCmp { return s }), ShouldBeNil)
+		So(grp.Create(), ShouldBeNil)
+		So(grp.Configure(), ShouldBeNil)
+		So(*grp.timeouts, ShouldResemble, Timeouts{PhaseStart: 50 * time.Millisecond, PhaseStop: time.Hour})
+
+		Convey("start should time out with the configured deadline", func() {
+			var te *TimeoutError
+			So(errors.As(grp.Start(), &te), ShouldBeTrue)
+			So(te.Phase, ShouldEqual, PhaseStart)
+			So(te.Group, ShouldEqual, "base")
+			So(te.Component, ShouldEqual, "*component.stuckCmp")
+			So(te.Timeout, ShouldEqual, 50*time.Millisecond)
+		})
+
+		Convey("stop should time out with the component deadline", func() {
+			var te *TimeoutError
+			err := grp.Stop()
+			So(errors.As(err, &te), ShouldBeTrue)
+			So(te, ShouldResemble, &TimeoutError{PhaseStop, "base", "*component.stuckCmp", 50 * time.Millisecond})
+			So(te.Error(), ShouldEqual, "stop of *component.stuckCmp in group base timed out after 50ms")
+		})
+	})
+
+	Convey("Timeouts in child groups should carry the path of the group", t, func() {
+		s := &stuckCmp{make(chan struct{})}
+		defer close(s.release)
+		root := New("base").(*group)
+		grp := root.New("db").(*group)
+		So(grp.Add(func() *stuckCmp { return s }), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+
+		var te *TimeoutError
+		So(errors.As(root.Start(), &te), ShouldBeTrue)
+		So(te.Group, ShouldEqual, "base/db")
+	})
+
+	Convey("Bad lifecycle configuration should fail configure", t, func() {
+		os.Args = []string{"group.test", "--config.mem", `{"lifecycle": {"begin": "50ms"}}`}
+		grp := New("base").(*group)
+		So(grp.Create(), ShouldBeNil)
+		So(grp.Configure(), ShouldBeError)
+
+		os.Args = []string{"group.test", "--config.mem", `{"lifecycle": {"start": "forever"}}`}
+		grp = New("base").(*group)
+		So(grp.Create(), ShouldBeNil)
+		So(grp.Configure(), ShouldBeError)
+	})
+
+	Convey("Create should time out with the root deadline", t, func() {
+		release := make(chan struct{})
+		defer close(release)
+		grp := newRoot("base", Timeouts{PhaseCreate: 50 * time.Millisecond})
+		So(grp.Add(func() *stuckCmp { <-release; return &stuckCmp{} }), ShouldBeNil)
+		So(grp.Create(), ShouldResemble, &TimeoutError{PhaseCreate, "base", "*component.stuckCmp", 50 * time.Millisecond})
+
+		// The abandoned constructor is discarded, the group can be stopped
+		So(grp.Stop(), ShouldBeNil)
+		So(grp.Invoke(func(*stuckCmp) {}), ShouldBeError)
+	})
+}
+
+func TestGroupState(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"group.test"}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we create a group hierarchy", t, func() {
+		root := New("root").(*group)
+		child := root.New("child").(*group)
+		transitions := []Transition{}
+		root.Subscribe(func(t Transition) { transitions = append(transitions, t) })
+		So(root.State(), ShouldEqual, StateNew)
+		So(child.State(), ShouldEqual, StateNew)
+
+		Convey("illegal transitions should be rejected", func() {
+			So(root.Stop(), ShouldResemble, &StateError{"root", PhaseStop, StateNew})
+			So(root.Configure(), ShouldResemble, &StateError{"root", PhaseConfigure, StateNew})
+			So(root.Start(), ShouldResemble, &StateError{"root", PhaseStart, StateNew})
+			So(root.Create(), ShouldBeNil)
+			So(root.Create(), ShouldResemble, &StateError{"root", PhaseCreate, StateCreated})
+			So(root.Start(), ShouldResemble, &StateError{"root", PhaseStart, StateCreated})
+			So(root.Configure(), ShouldBeNil)
+			So(root.Start(), ShouldBeNil)
+			So(root.Start(), ShouldResemble, &StateError{"root", PhaseStart, StateStarted})
+			So(root.Configure(), ShouldResemble, &StateError{"root", PhaseConfigure, StateStarted})
+			So(child.Configure(), ShouldResemble, &StateError{"root/child", PhaseConfigure, StateStarted})
+			So(root.Stop(), ShouldBeNil)
+			So(root.Stop(), ShouldResemble, &StateError{"root", PhaseStop, StateStopped})
+			So(root.Stop().Error(), ShouldEqual, "group root cannot stop in stopped state")
+		})
+
+		Convey("transitions should be published for the hierarchy", func() {
+			So(root.Create(), ShouldBeNil)
+			So(root.Configure(), ShouldBeNil)
+			So(root.Start(), ShouldBeNil)
+			So(root.State(), ShouldEqual, StateStarted)
+			So(child.State(), ShouldEqual, StateStarted)
+			So(root.Stop(), ShouldBeNil)
+			So(root.State(), ShouldEqual, StateStopped)
+			So(child.State(), ShouldEqual, StateStopped)
+			// Durations of the phases are not deterministic
+			for i := range transitions {
+				So(transitions[i].Duration, ShouldBeGreaterThanOrEqualTo, 0)
+				transitions[i].Duration = 0
+			}
+			So(transitions, ShouldResemble, []Transition{
+				{"root/child", StateNew, StateCreated, nil, PhaseCreate, 0},
+				{"root", StateNew, StateCreated, nil, PhaseCreate, 0},
+				{"root/child", StateCreated, StateConfigured, nil, PhaseConfigure, 0},
+				{"root", StateCreated, StateConfigured, nil, PhaseConfigure, 0},
+				{"root/child", StateConfigured, StateStarted, nil, PhaseStart, 0},
+				{"root", StateConfigured, StateStarted, nil, PhaseStart, 0},
+				{"root", StateStarted, StateStopping, nil, PhaseStop, 0},
+				{"root/child", StateStarted, StateStopping, nil, PhaseStop, 0},
+				{"root/child", StateStopping, StateStopped, nil, PhaseStop, 0},
+				{"root", StateStopping, StateStopped, nil, PhaseStop, 0},
+			})
+		})
+
+		Convey("hook completions should be published for the hierarchy", func() {
+			hooks := []HookEvent{}
+			var lock sync.Mutex
+			root.SubscribeHooks(func(e HookEvent) {
+				lock.Lock()
+				defer lock.Unlock()
+				hooks = append(hooks, e)
+			})
+			So(child.Add(newCmpWithHooks), ShouldBeNil)
+			So(root.Create(), ShouldBeNil)
+			So(root.Configure(), ShouldBeNil)
+			So(root.Start(), ShouldBeNil)
+			So(root.Stop(), ShouldBeNil)
+
+			So(hooks, ShouldHaveLength, 3)
+			for i, phase := range []Phase{PhaseConfigure, PhaseStart, PhaseStop} {
+				So(hooks[i].Group, ShouldEqual, "root/child")
+				So(hooks[i].Component, ShouldEqual, "*component.cmpWithHooks")
+				So(hooks[i].Phase, ShouldEqual, phase)
+				So(hooks[i].Start.IsZero(), ShouldBeFalse)
+				So(hooks[i].Err, ShouldBeNil)
+			}
+		})
+
+		Convey("failures should move the group to failed state", func() {
+			So(child.Add(newCmpWithErrors), ShouldBeNil)
+			So(root.Create(), ShouldBeNil)
+			So(root.Configure(), ShouldBeError)
+			So(root.State(), ShouldEqual, StateFailed)
+			So(child.State(), ShouldEqual, StateFailed)
+			last := transitions[len(transitions)-1]
+			So(last.Group, ShouldEqual, "root")
+			So(last.To, ShouldEqual, StateFailed)
+			So(last.Err, ShouldBeError, "root/child: *component.cmpWithErrors failed to configure: config error")
+			So(root.Stop(), ShouldBeError)
+			So(root.State(), ShouldEqual, StateFailed)
+		})
+	})
+
+	Convey("A group should reject phases while another phase is running", t, func() {
+		root := New("root").(*group)
+		s := &blockingCmp{make(chan struct{}), make(chan struct{})}
+		So(root.Add(func() *blockingCmp { return s }), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+
+		done := make(chan error)
+		go func() { done <- root.Start() }()
+		<-s.started
+		So(root.Stop(), ShouldResemble, &StateError{"root", PhaseStop, StateConfigured})
+		So(root.Start(), ShouldResemble, &StateError{"root", PhaseStart, StateConfigured})
+		close(s.release)
+		So(<-done, ShouldBeNil)
+		So(root.Stop(), ShouldBeNil)
+	})
+
+	Convey("States should have names", t, func() {
+		So(StateStopping.String(), ShouldEqual, "stopping")
+		So(State(100).String(), ShouldEqual, "state(100)")
+	})
+}
+
+var errStop = errors.New("stop error")
+
+type failStop struct{}
+
+func (f *failStop) Stop(ctx Context) error { return errStop }
+
+type failStopA struct{ failStop }
+type failStopB struct{ failStop }
+type failStopC struct{ failStop }
+
+func TestGroupStopErrors(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"group.test"}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("Stop should aggregate the errors of all the groups", t, func() {
+		root := New("root").(*group)
+		child := root.New("child").(*group)
+		So(root.Add(func() *failStopA { return &failStopA{} }), ShouldBeNil)
+		So(child.Add(func() *failStopB { return &failStopB{} }), ShouldBeNil)
+		So(child.Add(func() *failStopC { return &failStopC{} }), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+		So(root.Start(), ShouldBeNil)
+
+		err := root.Stop()
+		So(err, ShouldHaveSameTypeAs, MultiError{})
+		So(err.(MultiError), ShouldHaveLength, 3)
+		So(errors.Is(err, errStop), ShouldBeTrue)
+
+		// The entries record the group path and the component type
+		entries := map[string]bool{}
+		for _, e := range err.(MultiError) {
+			var ce *ComponentError
+			So(errors.As(e, &ce), ShouldBeTrue)
+			So(ce.Err, ShouldEqual, errStop)
+			entries[ce.Group+" "+ce.Component] = true
+		}
+		So(entries, ShouldResemble, map[string]bool{
+			"root *component.failStopA":       true,
+			"root/child *component.failStopB": true,
+			"root/child *component.failStopC": true,
+		})
+		So(err.Error(), ShouldStartWith, "3 errors occurred: ")
+		So(err.Error(), ShouldEndWith, "root: *component.failStopA failed to stop: stop error")
+	})
+}
+
+type rollbackCmp struct {
+	events    *lcEvents
+	name      string
+	startErr  error
+	stopErr   error
+	startWait time.Duration
+}
+
+func (r *rollbackCmp) Start(ctx Context) error {
+	time.Sleep(r.startWait)
+	r.events.add(r.name + ".start")
+	return r.startErr
+}
+
+func (r *rollbackCmp) Stop(ctx Context) error {
+	r.events.add(r.name + ".stop")
+	return r.stopErr
+}
+
+// IsHealthy reports the component healthy while it is started.
+func (r *rollbackCmp) IsHealthy(ctx Context) bool {
+	return r.startErr == nil && r.events.index(r.name+".start") >= 0 && r.events.index(r.name+".stop") < 0
+}
+
+type rbA struct{ rollbackCmp }
+type rbB struct{ rollbackCmp }
+type rbC struct{ rollbackCmp }
+type rbD struct{ rollbackCmp }
+type rbE struct{ rollbackCmp }
+
+func TestGroupStartRollback(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"group.test"}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we create a group hierarchy", t, func() {
+		events := &lcEvents{}
+		startErr := errors.New("start error")
+		stopErr := errors.New("stop error")
+		root := New("root").(*group)
+		good := root.New("good").(*group)
+		bad := root.New("bad").(*group)
+		So(root.Add(func() *rbA { return &rbA{rollbackCmp{events: events, name: "a"}} }), ShouldBeNil)
+		So(good.Add(func(*rbA) *rbB { return &rbB{rollbackCmp{events: events, name: "b", stopErr: stopErr}} }), ShouldBeNil)
+		So(bad.Add(func() *rbC { return &rbC{rollbackCmp{events: events, name: "c"}} }), ShouldBeNil)
+		So(bad.Add(func(*rbC) *rbD {
+			return &rbD{rollbackCmp{events: events, name: "d", startErr: startErr, startWait: 50 * time.Millisecond}}
+		}), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+
+		Convey("a failed start should roll back the started components", func() {
+			err := root.Start()
+			So(err, ShouldHaveSameTypeAs, MultiError{})
+			So(errors.Is(err, startErr), ShouldBeTrue)
+			So(errors.Is(err, stopErr), ShouldBeTrue)
+
+			// The errors capture the start failure and the rollback failure
+			phases := map[Phase]string{}
+			for _, e := range err.(MultiError) {
+				ce := e.(*ComponentError)
+				phases[ce.Phase] = ce.Group + " " + ce.Component
+			}
+			So(phases, ShouldResemble, map[Phase]string{
+				PhaseStart: "root/bad *component.rbD",
+				PhaseStop:  "root/good *component.rbB",
+			})
+
+			// All the started components and the failed one are stopped,
+			// dependents first
+			So(events.index("a.stop"), ShouldBeGreaterThan, events.index("b.stop"))
+			So(events.index("a.stop"), ShouldBeGreaterThan, events.index("c.stop"))
+			So(events.index("c.stop"), ShouldBeGreaterThan, events.index("d.stop"))
+			So(events.index("d.stop"), ShouldBeGreaterThanOrEqualTo, 0)
+
+			// Groups with rollback failures are left in the failed state
+			So(root.State(), ShouldEqual, StateFailed)
+			So(good.State(), ShouldEqual, StateFailed)
+			So(bad.State(), ShouldEqual, StateStopped)
+
+			// None of the components is left running
+			So(root.Health().Status, ShouldEqual, HealthUnhealthy)
+			for _, g := range []*group{root, good, bad} {
+				for c, h := range g.Health().Components {
+					So(g.path()+" "+c+" "+h.Status.String(), ShouldEndWith, " unhealthy")
+				}
+			}
+		})
+	})
+
+	Convey("After we create a group with a component that does not start in time", t, func() {
+		events := &lcEvents{}
+		root := newRoot("root", Timeouts{PhaseStart: 50 * time.Millisecond})
+		So(root.Add(func() *rbA { return &rbA{rollbackCmp{events: events, name: "a"}} }), ShouldBeNil)
+		So(root.Add(func(*rbA) *rbE { return &rbE{rollbackCmp{events: events, name: "e", startWait: time.Second}} }), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+
+		Convey("a start timeout should roll back the abandoned component", func() {
+			var te *TimeoutError
+			So(errors.As(root.Start(), &te), ShouldBeTrue)
+			So(te.Component, ShouldEqual, "*component.rbE")
+			So(events.index("e.stop"), ShouldBeGreaterThanOrEqualTo, 0)
+			So(events.index("e.stop"), ShouldBeLessThan, events.index("a.stop"))
+			So(root.State(), ShouldEqual, StateStopped)
+		})
+	})
+}
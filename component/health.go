@@ -0,0 +1,171 @@
+package component
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// HealthStatus is the health status of a component or a group.
+type HealthStatus int
+
+const (
+	// HealthHealthy indicates that the component is fully functional.
+	HealthHealthy HealthStatus = iota
+	// HealthDegraded indicates that the component is serving with reduced
+	// functionality or performance.
+	HealthDegraded
+	// HealthUnhealthy indicates that the component is not serving.
+	HealthUnhealthy
+)
+
+var healthNames = map[HealthStatus]string{
+	HealthHealthy:   "healthy",
+	HealthDegraded:  "degraded",
+	HealthUnhealthy: "unhealthy",
+}
+
+func (s HealthStatus) String() string {
+	if n, ok := healthNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("health(%d)", int(s))
+}
+
+// MarshalText encodes the health status as its name.
+func (s HealthStatus) MarshalText() ([]byte, error) {
+	if _, ok := healthNames[s]; !ok {
+		return nil, fmt.Errorf("unknown health status %d", int(s))
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes the health status from its name.
+func (s *HealthStatus) UnmarshalText(b []byte) error {
+	for status, n := range healthNames {
+		if n == string(b) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown health status %s", b)
+}
+
+// Health captures the health of a component. The message and the details
+// explain the status to the operators.
+type Health struct {
+	Status  HealthStatus           `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// HealthCheckHook is the interface that provides the detailed health callback for
+// the component. Components implementing it are not queried through HealthHook.
+type HealthCheckHook interface {
+	Health(ctx Context) Health
+}
+
+// AdaptHealthHook adapts a HealthHook to a HealthCheckHook. The component is
+// reported healthy if the hook returns true, else unhealthy.
+func AdaptHealthHook(h HealthHook) HealthCheckHook {
+	return &healthAdapter{h}
+}
+
+type healthAdapter struct {
+	h HealthHook
+}
+
+func (a *healthAdapter) Health(ctx Context) Health {
+	if a.h.IsHealthy(ctx) {
+		return Health{Status: HealthHealthy}
+	}
+	return Health{Status: HealthUnhealthy}
+}
+
+// HealthReport captures the health of a group. It mirrors the group hierarchy
+// with the health of the components of the group keyed by their types and the
+// reports of the child groups keyed by their names. The status of the group is
+// the worst status of its components and child groups, a group that is created
+// but not started is unhealthy whatever the health of its components.
+type HealthReport struct {
+	Group      string                   `json:"group"`
+	Status     HealthStatus             `json:"status"`
+	Components map[string]Health        `json:"components,omitempty"`
+	Groups     map[string]*HealthReport `json:"groups,omitempty"`
+}
+
+// Health returns the health report of the group and all its child groups. If
+// a health monitor is watching the group hierarchy, the health of the components
+// is served from the results cached by the monitor.
+func (g *group) Health() *HealthReport {
+	m := g.root().healthMonitor()
+	return g.healthReport(func(g *group, cmp string, h HealthCheckHook) Health {
+		if m != nil {
+			if health, ok := m.cached(g.path(), cmp); ok {
+				return health
+			}
+		}
+		return h.Health(g.ctx)
+	})
+}
+
+// healthReport builds the health report of the group and all its child groups
+// using the check function to evaluate the health of each component.
+func (g *group) healthReport(check func(g *group, cmp string, h HealthCheckHook) Health) *HealthReport {
+	r := &HealthReport{
+		Group:      g.path(),
+		Status:     HealthHealthy,
+		Components: map[string]Health{},
+		Groups:     map[string]*HealthReport{},
+	}
+	for _, h := range g.healthHooks {
+		health := check(g, h.name, h.hook)
+		r.Components[h.name] = health
+		r.Status = worstHealth(r.Status, health.Status)
+	}
+
+	for name, child := range g.children {
+		cr := child.healthReport(check)
+		r.Groups[name] = cr
+		r.Status = worstHealth(r.Status, cr.Status)
+	}
+
+	// A new group has no component to report on yet
+	if s := g.State(); s != StateNew && s != StateStarted {
+		r.Status = HealthUnhealthy
+	}
+	return r
+}
+
+// IsHealthy returns true if the groups of the hierarchy are started and none of
+// their components is unhealthy. Degraded components are still serving and
+// hence considered healthy.
+func (g *group) IsHealthy() bool {
+	return g.Health().Status != HealthUnhealthy
+}
+
+// componentHealth is the health hook of a component along with the name the
+// component is reported under.
+type componentHealth struct {
+	name string
+	hook HealthCheckHook
+}
+
+// addHealthHook caches the health hook of the component if it provides one.
+// The hooks are tracked by the type of the component in the container like the
+// other lifecycle hooks.
+func (g *group) addHealthHook(v reflect.Value) {
+	val := v.Interface()
+	name := v.Type().String()
+	if h, ok := val.(HealthCheckHook); ok {
+		g.healthHooks[valueType(v)] = componentHealth{name, h}
+	} else if h, ok := val.(HealthHook); ok {
+		g.healthHooks[valueType(v)] = componentHealth{name, AdaptHealthHook(h)}
+	}
+}
+
+func worstHealth(s1, s2 HealthStatus) HealthStatus {
+	if s2 > s1 {
+		return s2
+	}
+	return s1
+}
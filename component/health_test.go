@@ -0,0 +1,101 @@
+package component
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type degradedCmp struct {
+	status HealthStatus
+}
+
+func (d *degradedCmp) Health(ctx Context) Health {
+	return Health{
+		Status:  d.status,
+		Message: "replica lag",
+		Details: map[string]interface{}{"lag": 10},
+	}
+}
+
+// IsHealthy is ignored as the component provides the detailed health hook
+func (d *degradedCmp) IsHealthy(ctx Context) bool { return false }
+
+func TestGroupHealth(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"group.test"}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we create a group hierarchy", t, func() {
+		root := New("root").(*group)
+		child := root.New("child").(*group)
+		d := &degradedCmp{HealthDegraded}
+		So(root.Add(newCmpWithHooks), ShouldBeNil)
+		So(child.Add(func() *degradedCmp { return d }), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+		So(root.Start(), ShouldBeNil)
+
+		Convey("the health report should mirror the hierarchy", func() {
+			r := root.Health()
+			So(r.Group, ShouldEqual, "root")
+			So(r.Status, ShouldEqual, HealthDegraded)
+			So(r.Components, ShouldResemble, map[string]Health{
+				"*component.cmpWithHooks": {Status: HealthHealthy},
+			})
+			So(r.Groups, ShouldHaveLength, 1)
+			cr := r.Groups["child"]
+			So(cr.Group, ShouldEqual, "root/child")
+			So(cr.Status, ShouldEqual, HealthDegraded)
+			So(cr.Components["*component.degradedCmp"].Message, ShouldEqual, "replica lag")
+			So(cr.Groups, ShouldBeEmpty)
+
+			// The hooks are tracked by the type of the component in the container
+			So(child.healthHooks, ShouldContainKey, reflect.TypeOf(degradedCmp{}))
+
+			// Degraded components are still serving
+			So(root.IsHealthy(), ShouldBeTrue)
+		})
+
+		Convey("unhealthy components should make the hierarchy unhealthy", func() {
+			d.status = HealthUnhealthy
+			So(root.Health().Status, ShouldEqual, HealthUnhealthy)
+			So(root.IsHealthy(), ShouldBeFalse)
+			So(child.IsHealthy(), ShouldBeFalse)
+		})
+
+		Convey("bool health hooks should be adapted", func() {
+			So(root.Stop(), ShouldBeNil)
+			So(root.Health().Components["*component.cmpWithHooks"].Status, ShouldEqual, HealthUnhealthy)
+			So(root.IsHealthy(), ShouldBeFalse)
+		})
+
+		Convey("groups that are not started should be unhealthy", func() {
+			So(child.Stop(), ShouldBeNil)
+			r := root.Health()
+			So(r.Groups["child"].Status, ShouldEqual, HealthUnhealthy)
+			So(r.Groups["child"].Components["*component.degradedCmp"].Status, ShouldEqual, HealthDegraded)
+			So(r.Status, ShouldEqual, HealthUnhealthy)
+			So(root.IsHealthy(), ShouldBeFalse)
+		})
+
+		Convey("the health report should be encoded as json", func() {
+			b, err := json.Marshal(root.Health().Groups["child"])
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `{"group":"root/child","status":"degraded","components":`+
+				`{"*component.degradedCmp":{"status":"degraded","message":"replica lag","details":{"lag":10}}}}`)
+
+			h := Health{}
+			So(json.Unmarshal([]byte(`{"status":"unhealthy"}`), &h), ShouldBeNil)
+			So(h.Status, ShouldEqual, HealthUnhealthy)
+			So(json.Unmarshal([]byte(`{"status":"sick"}`), &h), ShouldBeError)
+			_, err = json.Marshal(Health{Status: HealthStatus(10)})
+			So(err, ShouldBeError)
+			So(HealthStatus(10).String(), ShouldEqual, "health(10)")
+		})
+	})
+}
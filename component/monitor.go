@@ -0,0 +1,230 @@
+package component
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anuvu/cube/config"
+)
+
+// HealthEvent is published by the health monitor when the health status of a
+// component changes between two consecutive checks.
+type HealthEvent struct {
+	Group     string
+	Component string
+	From      Health
+	To        Health
+}
+
+// HealthMonitor periodically checks the health of all the components in the
+// group hierarchy and caches the results. Once the monitor is running, the
+// health reports of the groups are served from the cache instead of calling
+// the health hooks of the components on every request. Once the monitor is
+// stopped the health hooks are called again.
+//
+// The monitor is configured under the "health_monitor" key with the check
+// interval and the deadline of each check as duration strings, for example
+// {"health_monitor": {"interval": "10s", "timeout": "2s"}}. A check that misses
+// its deadline reports the component as unhealthy.
+type HealthMonitor struct {
+	config    *monitorConfig
+	root      *group
+	interval  time.Duration
+	timeout   time.Duration
+	lock      sync.RWMutex
+	checkLock sync.Mutex
+	running   bool
+	cache     map[string]map[string]Health
+	observers []func(HealthEvent)
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type monitorConfig struct {
+	config.BaseConfig
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout"`
+}
+
+// NewHealthMonitor creates a health monitor for the group hierarchy. Add it to
+// a group to opt-in to the periodic health checks. The root must be a group
+// created by New.
+func NewHealthMonitor(root Group) (*HealthMonitor, error) {
+	g, ok := root.(*group)
+	if !ok {
+		return nil, errors.New("health monitor requires a group created by component.New")
+	}
+	m := &HealthMonitor{
+		config: &monitorConfig{
+			BaseConfig: config.BaseConfig{ConfigKey: "health_monitor"},
+			Interval:   "10s",
+			Timeout:    "2s",
+		},
+		root:  g,
+		cache: map[string]map[string]Health{},
+	}
+	m.root.setHealthMonitor(m)
+	return m, nil
+}
+
+// Config returns the configuration of the health monitor.
+func (m *HealthMonitor) Config() config.Config {
+	return m.config
+}
+
+// Configure parses the check interval and deadline, both must be positive.
+func (m *HealthMonitor) Configure(ctx Context) error {
+	var err error
+	if m.interval, err = time.ParseDuration(m.config.Interval); err != nil {
+		return err
+	}
+	if m.interval <= 0 {
+		return fmt.Errorf("health monitor interval %s is not positive", m.interval)
+	}
+	if m.timeout, err = time.ParseDuration(m.config.Timeout); err != nil {
+		return err
+	}
+	if m.timeout <= 0 {
+		return fmt.Errorf("health monitor timeout %s is not positive", m.timeout)
+	}
+	return nil
+}
+
+// Start starts the periodic health checks.
+func (m *HealthMonitor) Start(ctx Context) error {
+	m.lock.Lock()
+	m.running = true
+	m.lock.Unlock()
+	m.done = make(chan struct{})
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			m.Check()
+			select {
+			case <-ticker.C:
+			case <-m.done:
+				return
+			case <-ctx.Ctx().Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the periodic health checks and drops the cached results.
+func (m *HealthMonitor) Stop(ctx Context) error {
+	if m.done == nil {
+		return nil
+	}
+	close(m.done)
+	m.wg.Wait()
+	m.done = nil
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.running = false
+	m.cache = map[string]map[string]Health{}
+	return nil
+}
+
+// Subscribe registers a function that is called on every health status change
+// of the components.
+func (m *HealthMonitor) Subscribe(f func(HealthEvent)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.observers = append(m.observers, f)
+}
+
+// Health returns the cached health report of the group hierarchy.
+func (m *HealthMonitor) Health() *HealthReport {
+	return m.root.Health()
+}
+
+// Check checks the health of all the components in the group hierarchy, updates
+// the cache and publishes the health status changes. Concurrent checks are
+// serialized so that every change is published once.
+func (m *HealthMonitor) Check() {
+	m.checkLock.Lock()
+	defer m.checkLock.Unlock()
+
+	cache := map[string]map[string]Health{}
+	events := []HealthEvent{}
+
+	m.lock.RLock()
+	prev := m.cache
+	m.lock.RUnlock()
+
+	m.root.healthReport(func(g *group, cmp string, h HealthCheckHook) Health {
+		health := m.check(g, h)
+		path := g.path()
+		if cache[path] == nil {
+			cache[path] = map[string]Health{}
+		}
+		cache[path][cmp] = health
+		if from, ok := prev[path][cmp]; ok && from.Status != health.Status {
+			events = append(events, HealthEvent{path, cmp, from, health})
+		}
+		return health
+	})
+
+	m.lock.Lock()
+	m.cache = cache
+	observers := append([]func(HealthEvent){}, m.observers...)
+	m.lock.Unlock()
+
+	for _, e := range events {
+		m.root.ctx.Log().Info().Str("group", e.Group).Str("component", e.Component).
+			Str("from", e.From.Status.String()).Str("to", e.To.Status.String()).Msg("health changed")
+		for _, f := range observers {
+			f(e)
+		}
+	}
+}
+
+// check calls the health hook of the component, if the hook does not complete
+// within the check deadline it is abandoned and the component is unhealthy.
+func (m *HealthMonitor) check(g *group, h HealthCheckHook) Health {
+	hch := make(chan Health, 1)
+	go func() {
+		hch <- h.Health(g.ctx)
+	}()
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	select {
+	case health := <-hch:
+		return health
+	case <-timer.C:
+		return Health{Status: HealthUnhealthy, Message: "health check timed out after " + m.timeout.String()}
+	}
+}
+
+// cached returns the cached health of the component while the monitor is
+// running.
+func (m *HealthMonitor) cached(group, cmp string) (Health, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if !m.running {
+		return Health{}, false
+	}
+	h, ok := m.cache[group][cmp]
+	return h, ok
+}
+
+func (g *group) setHealthMonitor(m *HealthMonitor) {
+	g.stateLock.Lock()
+	defer g.stateLock.Unlock()
+	g.monitor = m
+}
+
+func (g *group) healthMonitor() *HealthMonitor {
+	g.stateLock.Lock()
+	defer g.stateLock.Unlock()
+	return g.monitor
+}
@@ -0,0 +1,161 @@
+package component
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type flakyCmp struct {
+	lock   sync.Mutex
+	status HealthStatus
+	calls  int
+	delay  time.Duration
+}
+
+func (f *flakyCmp) Health(ctx Context) Health {
+	f.lock.Lock()
+	f.calls++
+	status, delay := f.status, f.delay
+	f.lock.Unlock()
+	time.Sleep(delay)
+	return Health{Status: status}
+}
+
+func (f *flakyCmp) set(status HealthStatus, delay time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.status, f.delay = status, delay
+}
+
+func (f *flakyCmp) numCalls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls
+}
+
+func TestHealthMonitor(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"group.test", "--config.mem", `{"health_monitor": {"interval": "1h", "timeout": "50ms"}}`}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we start a group hierarchy with a health monitor", t, func() {
+		root := New("root").(*group)
+		child := root.New("child").(*group)
+		f := &flakyCmp{}
+		var m *HealthMonitor
+		So(root.Add(NewHealthMonitor), ShouldBeNil)
+		So(child.Add(func() *flakyCmp { return f }), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Invoke(func(hm *HealthMonitor) { m = hm }), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+
+		var lock sync.Mutex
+		events := []HealthEvent{}
+		m.Subscribe(func(e HealthEvent) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, e)
+		})
+		So(root.Start(), ShouldBeNil)
+		defer root.Stop()
+
+		// The first check runs as soon as the monitor starts
+		for f.numCalls() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		Convey("the health report should be served from the cache", func() {
+			So(root.IsHealthy(), ShouldBeTrue)
+			So(m.Health().Groups["child"].Status, ShouldEqual, HealthHealthy)
+			So(f.numCalls(), ShouldEqual, 1)
+
+			f.set(HealthUnhealthy, 0)
+			So(root.IsHealthy(), ShouldBeTrue)
+		})
+
+		Convey("status changes should be published on the next check", func() {
+			f.set(HealthDegraded, 0)
+			m.Check()
+			So(root.Health().Status, ShouldEqual, HealthDegraded)
+
+			// Unchanged status should not be published
+			m.Check()
+
+			lock.Lock()
+			defer lock.Unlock()
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Group, ShouldEqual, "root/child")
+			So(events[0].Component, ShouldEqual, "*component.flakyCmp")
+			So(events[0].From.Status, ShouldEqual, HealthHealthy)
+			So(events[0].To.Status, ShouldEqual, HealthDegraded)
+		})
+
+		Convey("checks exceeding the deadline should report unhealthy", func() {
+			f.set(HealthHealthy, 200*time.Millisecond)
+			start := time.Now()
+			m.Check()
+			So(time.Since(start), ShouldBeLessThan, 200*time.Millisecond)
+
+			r := root.Health().Groups["child"]
+			So(r.Status, ShouldEqual, HealthUnhealthy)
+			So(r.Components["*component.flakyCmp"].Message, ShouldEqual, "health check timed out after 50ms")
+			So(root.IsHealthy(), ShouldBeFalse)
+		})
+
+		Convey("concurrent checks should publish a change once", func() {
+			f.set(HealthDegraded, 0)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					m.Check()
+				}()
+			}
+			wg.Wait()
+
+			lock.Lock()
+			defer lock.Unlock()
+			So(events, ShouldHaveLength, 1)
+		})
+
+		Convey("a stopped monitor should not serve the cache", func() {
+			So(m.Stop(root.ctx), ShouldBeNil)
+			So(m.Stop(root.ctx), ShouldBeNil)
+			f.set(HealthUnhealthy, 0)
+			So(root.IsHealthy(), ShouldBeFalse)
+			So(m.Start(root.ctx), ShouldBeNil)
+		})
+	})
+
+	Convey("A health monitor should reject the durations that are not positive", t, func() {
+		for _, cfg := range []string{
+			`{"health_monitor": {"interval": "0s"}}`,
+			`{"health_monitor": {"interval": "-1s"}}`,
+			`{"health_monitor": {"timeout": "0s"}}`,
+			`{"health_monitor": {"timeout": "x"}}`,
+		} {
+			os.Args = []string{"group.test", "--config.mem", cfg}
+			root := New("root").(*group)
+			So(root.Add(NewHealthMonitor), ShouldBeNil)
+			So(root.Create(), ShouldBeNil)
+			So(root.Configure(), ShouldBeError)
+		}
+	})
+
+	Convey("A health monitor should require a group created by New", t, func() {
+		m, err := NewHealthMonitor(otherGroup{})
+		So(m, ShouldBeNil)
+		So(err, ShouldBeError)
+	})
+}
+
+type otherGroup struct {
+	Group
+}
@@ -0,0 +1,73 @@
+package component
+
+import (
+	"reflect"
+	"sync"
+)
+
+// schedule invokes f for every type in the dependency graph. Types that have
+// no dependency between them are processed concurrently and each type is
+// processed only after all the types it depends on are done.
+//
+// If reverse is true the order is flipped and each type is processed only after
+// all the types that depend on it are done. This is used to tear down components
+// in the reverse order of their creation.
+//
+// In the forward order a failure of f abandons the processing of all the types
+// that depend on the failed type. In the reverse order every type is processed
+// irrespective of failures. All the errors returned by f are returned to the
+// caller.
+func schedule(deps map[reflect.Type][]reflect.Type, reverse bool, f func(reflect.Type) error) []error {
+	// Compute the set of types each type has to wait for.
+	waitFor := make(map[reflect.Type][]reflect.Type, len(deps))
+	for t, ds := range deps {
+		if reverse {
+			for _, d := range ds {
+				waitFor[d] = append(waitFor[d], t)
+			}
+		} else {
+			waitFor[t] = ds
+		}
+	}
+
+	done := make(map[reflect.Type]chan struct{}, len(deps))
+	for t := range deps {
+		done[t] = make(chan struct{})
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	failed := map[reflect.Type]bool{}
+	errs := []error{}
+	for t := range deps {
+		wg.Add(1)
+		go func(t reflect.Type) {
+			defer wg.Done()
+			defer close(done[t])
+
+			abandon := false
+			for _, w := range waitFor[t] {
+				<-done[w]
+				lock.Lock()
+				abandon = abandon || (!reverse && failed[w])
+				lock.Unlock()
+			}
+
+			if abandon {
+				lock.Lock()
+				failed[t] = true
+				lock.Unlock()
+				return
+			}
+
+			if err := f(t); err != nil {
+				lock.Lock()
+				failed[t] = true
+				errs = append(errs, err)
+				lock.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	return errs
+}
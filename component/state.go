@@ -0,0 +1,204 @@
+package component
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is the lifecycle state of a group.
+type State int
+
+const (
+	// StateNew is the state of a group that is not yet created.
+	StateNew State = iota
+	// StateCreated is the state of a group whose components are constructed.
+	StateCreated
+	// StateConfigured is the state of a group whose components are configured.
+	StateConfigured
+	// StateStarted is the state of a group whose components are started.
+	StateStarted
+	// StateStopping is the state of a group whose components are being stopped.
+	StateStopping
+	// StateStopped is the state of a group whose components are stopped.
+	StateStopped
+	// StateFailed is the state of a group in which a lifecycle phase failed.
+	StateFailed
+)
+
+var stateNames = map[State]string{
+	StateNew:        "new",
+	StateCreated:    "created",
+	StateConfigured: "configured",
+	StateStarted:    "started",
+	StateStopping:   "stopping",
+	StateStopped:    "stopped",
+	StateFailed:     "failed",
+}
+
+func (s State) String() string {
+	if n, ok := stateNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// allowedStates maps each lifecycle phase to the states of the group the phase
+// can be entered from. A group can be stopped from any state once it is created
+// so that the components that are already running can be torn down.
+var allowedStates = map[Phase][]State{
+	PhaseCreate:    {StateNew},
+	PhaseConfigure: {StateCreated},
+	PhaseStart:     {StateConfigured},
+	PhaseStop:      {StateCreated, StateConfigured, StateStarted, StateFailed},
+}
+
+// Transition is the event published when a group changes its lifecycle state.
+// Err is the error that caused the group to move to the failed state. Phase is
+// the lifecycle phase that caused the transition and Duration is the time the
+// group spent in that phase until the transition.
+type Transition struct {
+	Group    string
+	From     State
+	To       State
+	Err      error
+	Phase    Phase
+	Duration time.Duration
+}
+
+// HookEvent is the event published when a lifecycle hook of a component
+// completes. Err is the error returned by the hook.
+type HookEvent struct {
+	Group     string
+	Component string
+	Phase     Phase
+	Start     time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+// StateError is returned when a lifecycle phase is not allowed in the current
+// state of the group.
+type StateError struct {
+	Group string
+	Phase Phase
+	State State
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("group %s cannot %s in %s state", e.Group, e.Phase, e.State)
+}
+
+// State returns the lifecycle state of the group.
+func (g *group) State() State {
+	g.stateLock.Lock()
+	defer g.stateLock.Unlock()
+	return g.state
+}
+
+// Subscribe registers a function that is called on every state transition of
+// the group and all its descendants. As child groups transition concurrently
+// the function must be safe for concurrent use.
+func (g *group) Subscribe(f func(Transition)) {
+	g.stateLock.Lock()
+	defer g.stateLock.Unlock()
+	g.observers = append(g.observers, f)
+}
+
+// SubscribeHooks registers a function that is called on completion of every
+// lifecycle hook of the components of the group and all its descendants. As the
+// hooks complete concurrently the function must be safe for concurrent use.
+func (g *group) SubscribeHooks(f func(HookEvent)) {
+	g.stateLock.Lock()
+	defer g.stateLock.Unlock()
+	g.hookObservers = append(g.hookObservers, f)
+}
+
+// enter checks that the phase is allowed in the current state of the group and
+// that the group is not in another phase already. If the phase moves the group
+// to an intermediate state the group moves to that state right away.
+func (g *group) enter(phase Phase) error {
+	g.stateLock.Lock()
+	from := g.state
+	allowed := false
+	for _, s := range allowedStates[phase] {
+		allowed = allowed || s == from
+	}
+	if !allowed || g.busy {
+		g.stateLock.Unlock()
+		return &StateError{Group: g.path(), Phase: phase, State: from}
+	}
+	g.busy = true
+	g.phase = phase
+	g.phaseStart = time.Now()
+	g.stateLock.Unlock()
+
+	if phase == PhaseStop {
+		g.moveTo(StateStopping, nil)
+	}
+	return nil
+}
+
+// exit moves the group to the specified state, or to the failed state if the
+// phase failed with an error. It returns the error for convenience.
+func (g *group) exit(to State, err error) error {
+	if err != nil {
+		to = StateFailed
+	}
+	g.moveTo(to, err)
+	return err
+}
+
+// moveTo moves the group to the specified state and notifies the observers of
+// this group and its ancestors. The group leaves its phase unless the state is
+// an intermediate one.
+func (g *group) moveTo(to State, err error) {
+	g.stateLock.Lock()
+	g.busy = to == StateStopping
+	t := Transition{
+		Group:    g.path(),
+		From:     g.state,
+		To:       to,
+		Err:      err,
+		Phase:    g.phase,
+		Duration: time.Since(g.phaseStart),
+	}
+	g.state = to
+	g.stateLock.Unlock()
+
+	for p := g; p != nil; p = p.parent {
+		p.stateLock.Lock()
+		observers := append([]func(Transition){}, p.observers...)
+		p.stateLock.Unlock()
+		for _, f := range observers {
+			f(t)
+		}
+	}
+}
+
+// publishHook notifies the hook observers of this group and its ancestors.
+func (g *group) publishHook(e HookEvent) {
+	for p := g; p != nil; p = p.parent {
+		p.stateLock.Lock()
+		observers := append([]func(HookEvent){}, p.hookObservers...)
+		p.stateLock.Unlock()
+		for _, f := range observers {
+			f(e)
+		}
+	}
+}
+
+// root returns the root group of the hierarchy.
+func (g *group) root() *group {
+	if g.parent == nil {
+		return g
+	}
+	return g.parent.root()
+}
+
+// path returns the names of the ancestors and the group separated by "/".
+func (g *group) path() string {
+	if g.parent == nil {
+		return g.name
+	}
+	return g.parent.path() + "/" + g.name
+}
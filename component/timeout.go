@@ -0,0 +1,149 @@
+package component
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/anuvu/cube/config"
+)
+
+// Phase identifies a phase of the component lifecycle.
+type Phase string
+
+const (
+	// PhaseCreate is the phase in which the components are constructed.
+	PhaseCreate Phase = "create"
+	// PhaseConfigure is the phase in which the config hooks are called.
+	PhaseConfigure Phase = "configure"
+	// PhaseStart is the phase in which the start hooks are called.
+	PhaseStart Phase = "start"
+	// PhaseStop is the phase in which the stop hooks are called.
+	PhaseStop Phase = "stop"
+)
+
+// Timeouts maps the lifecycle phases to the deadline of each hook called in
+// that phase. A phase without a deadline is not time bounded.
+//
+// In the configuration store the timeouts are captured under the "lifecycle" key
+// as duration strings, for example {"lifecycle": {"start": "10s", "stop": "5s"}}.
+type Timeouts map[Phase]time.Duration
+
+// DefaultTimeouts are the deadlines used by the groups unless overridden by the
+// configuration. The components are constructed before the configuration is
+// loaded, hence the create deadline of each constructor can only be set through
+// the defaults.
+var DefaultTimeouts = Timeouts{}
+
+// UnmarshalJSON decodes the timeouts from a map of phases to duration strings.
+func (t *Timeouts) UnmarshalJSON(b []byte) error {
+	v := map[Phase]string{}
+	if e := json.Unmarshal(b, &v); e != nil {
+		return e
+	}
+	timeouts := Timeouts{}
+	for p, s := range v {
+		switch p {
+		case PhaseCreate, PhaseConfigure, PhaseStart, PhaseStop:
+		default:
+			return fmt.Errorf("unknown lifecycle phase %s", p)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		timeouts[p] = d
+	}
+	*t = timeouts
+	return nil
+}
+
+// TimeoutHook is the interface that components implement to override the group
+// deadlines of their lifecycle hooks. Phases that are not present in the returned
+// timeouts use the group deadlines.
+type TimeoutHook interface {
+	Timeouts() Timeouts
+}
+
+// TimeoutError is returned when a lifecycle hook does not complete within the
+// deadline of its phase.
+type TimeoutError struct {
+	Phase     Phase
+	Group     string
+	Component string
+	Timeout   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Component == "" {
+		return fmt.Sprintf("%s of group %s timed out after %s", e.Phase, e.Group, e.Timeout)
+	}
+	return fmt.Sprintf("%s of %s in group %s timed out after %s", e.Phase, e.Component, e.Group, e.Timeout)
+}
+
+type lifecycleConfig struct {
+	config.BaseConfig
+	Timeouts
+}
+
+// deadline returns the deadline of the phase for the component. If cmp is nil
+// the group deadline is returned.
+func (g *group) deadline(phase Phase, cmp interface{}) time.Duration {
+	if h, ok := cmp.(TimeoutHook); ok {
+		if d, ok := h.Timeouts()[phase]; ok {
+			return d
+		}
+	}
+	return (*g.timeouts)[phase]
+}
+
+// withDeadline calls the lifecycle hook f of the component and waits for it to
+// complete within the deadline of the phase for the component. If the deadline
+// passes f is abandoned and a timeout error is returned, f is left to complete
+// in the background. The completion of the hook is published to the hook
+// observers.
+func (g *group) withDeadline(phase Phase, cmp interface{}, f func() error) error {
+	start := time.Now()
+	name := reflect.TypeOf(cmp).String()
+	err := g.callWithDeadline(phase, name, g.deadline(phase, cmp), f)
+	g.publishHook(HookEvent{
+		Group:     g.path(),
+		Component: name,
+		Phase:     phase,
+		Start:     start,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+	return err
+}
+
+// createWithDeadline is a di.Caller bounding each constructor of the group by
+// the create deadline. The values of an abandoned constructor are discarded by
+// the container, hence it never touches the container once abandoned.
+func (g *group) createWithDeadline(ctr interface{}, call func() error) error {
+	name := reflect.TypeOf(ctr).Out(0).String()
+	return g.callWithDeadline(PhaseCreate, name, g.deadline(PhaseCreate, nil), call)
+}
+
+func (g *group) callWithDeadline(phase Phase, name string, d time.Duration, f func() error) error {
+	if d <= 0 {
+		return f()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		err := &TimeoutError{Phase: phase, Group: g.path(), Component: name, Timeout: d}
+		g.ctx.Log().Error().Error(err).Str("component", name).Msg("abandoning lifecycle hook")
+		return err
+	}
+}
@@ -1,5 +1,7 @@
 package config
 
+import "fmt"
+
 // Key uniquely identifies a configuration object in the configuration store.
 type Key string
 
@@ -28,6 +30,16 @@ type Store interface {
 	Get(Config) error
 }
 
+// NotFoundError is returned by the stores when the configuration of a key is
+// not present in the store.
+type NotFoundError struct {
+	Key Key
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s key not found", e.Key)
+}
+
 // BaseConfig provides a default implementation for Config interface.
 type BaseConfig struct {
 	ConfigKey Key
@@ -2,7 +2,6 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 )
 
@@ -59,7 +58,7 @@ func (j *jsonStore) Get(config Config) error {
 		}
 		return nil
 	}
-	return fmt.Errorf("%s key not found", name)
+	return &NotFoundError{name}
 }
 
 type cfgData struct {
@@ -67,6 +66,8 @@ type cfgData struct {
 }
 
 func (d *cfgData) UnmarshalJSON(b []byte) error {
-	d.b = b
+	// The decoder reuses its buffer for the next objects of the stream, so
+	// keep a copy of the data around
+	d.b = append([]byte(nil), b...)
 	return nil
 }
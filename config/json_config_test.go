@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -51,6 +52,7 @@ func TestJSONStore(t *testing.T) {
 			Convey("should not find randon config", func() {
 				err := s.Get(&httpConfig{BaseConfig{"some_random_key"}, 0})
 				So(err, ShouldBeError)
+				So(err, ShouldResemble, &NotFoundError{"some_random_key"})
 			})
 			Convey("should return default config on empty key", func() {
 				cfg := &httpConfig{BaseConfig{""}, 9999}
@@ -75,6 +77,20 @@ func TestJSONStore(t *testing.T) {
 	})
 }
 
+func TestJSONStream(t *testing.T) {
+	Convey("On a json store reading a stream in small chunks", t, func() {
+		r := iotest.OneByteReader(strings.NewReader(`{"http": {"port": 8080}}
+			{"logger": {"file": "/var/log/test.log"}}`))
+		s := NewJSONStore(r)
+		So(s.Open(), ShouldBeNil)
+		Convey("the data of the previous objects should be retained", func() {
+			cfg := &httpConfig{BaseConfig{"http"}, 0}
+			So(s.Get(cfg), ShouldBeNil)
+			So(cfg.Port, ShouldEqual, 8080)
+		})
+	})
+}
+
 func TestBadJSON(t *testing.T) {
 	Convey("Load bad json data", t, func() {
 		Convey("should be a json parse error", func() {
@@ -40,19 +40,13 @@ func Main(initF ServerInit) {
 	}
 
 	// Create the groups
-	if err := base.Create(); err != nil {
-		panic(err)
-	}
+	mustSucceed(base, base.Create())
 
 	// Configure the server
-	if err := base.Configure(); err != nil {
-		panic(err)
-	}
+	mustSucceed(base, base.Configure())
 
 	// Start the server
-	if err := base.Start(); err != nil {
-		panic(err)
-	}
+	mustSucceed(base, base.Start())
 
 	if invoker != nil {
 		if err := invoker(); err != nil {
@@ -66,9 +60,30 @@ func Main(initF ServerInit) {
 	})
 
 	// Stop all the components and exit
-	if err := base.Stop(); err != nil {
-		panic(err)
+	mustSucceed(base, base.Stop())
+}
+
+// mustSucceed logs every failure aggregated in the lifecycle error and panics
+// with the error, it does nothing if the error is nil.
+func mustSucceed(g component.Group, err error) {
+	if err == nil {
+		return
+	}
+
+	errs, ok := err.(component.MultiError)
+	if !ok {
+		errs = component.MultiError{err}
 	}
+	g.Invoke(func(ctx component.Context) {
+		for _, e := range errs {
+			ev := ctx.Log().Error().Error(e)
+			if ce, ok := e.(*component.ComponentError); ok {
+				ev = ev.Str("group", ce.Group).Str("component", ce.Component)
+			}
+			ev.Msg("lifecycle failure")
+		}
+	})
+	panic(err)
 }
 
 type shutDownHandler struct {
@@ -51,6 +51,17 @@ type ValueProcessor func(reflect.Value) error
 //
 // Note the any return values from the invoked function are not cached the container.
 func (c *Container) Invoke(fx interface{}, vp ValueProcessor) error {
+	return c.invoke(fx, vp, nil)
+}
+
+// Caller calls a constructor through call, which invokes the constructor and
+// returns its error. Callers are used to observe or to bound the construction
+// of the objects. A caller must either return the error of call or return an
+// error of its own, in which case the constructor is abandoned and the values
+// it produces are discarded.
+type Caller func(ctr interface{}, call func() error) error
+
+func (c *Container) invoke(fx interface{}, vp ValueProcessor, caller Caller) error {
 	// Check for function type
 	f := reflect.TypeOf(fx)
 	if e := checkFunc(fx, f); e != nil {
@@ -63,15 +74,25 @@ func (c *Container) Invoke(fx interface{}, vp ValueProcessor) error {
 		return err
 	}
 
-	// Call the function
-	returned := reflect.ValueOf(fx).Call(args)
-
-	// Check for errors
-	if err := checkError(returned); err != nil {
+	// Call the function and check for errors
+	var returned []reflect.Value
+	call := func() error {
+		returned = reflect.ValueOf(fx).Call(args)
+		return checkError(returned)
+	}
+	if caller != nil {
+		err = caller(fx, call)
+	} else {
+		err = call()
+	}
+	if err != nil {
 		return err
 	}
 
-	// Process results if a processor is provided
+	// Process results if a processor is provided, the error is not a result
+	if n := len(returned); n > 0 && returned[n-1].Type().Implements(_errType) {
+		returned = returned[:n-1]
+	}
 	if vp != nil {
 		for _, v := range returned {
 			if err := vp(v); err != nil {
@@ -96,6 +117,11 @@ func (c *Container) Invoke(fx interface{}, vp ValueProcessor) error {
 // If a value processor is provided, Create calls the value processor function on all returned
 // values of each constructor. This can used to cache/use the values outside the container.
 func (c *Container) Create(vp ValueProcessor) error {
+	return c.CreateWith(vp, nil)
+}
+
+// CreateWith is Create calling each constructor through the caller.
+func (c *Container) CreateWith(vp ValueProcessor, caller Caller) error {
 	vals := []reflect.Value{}
 	resProc := func(v reflect.Value) error {
 		t := baseType(v.Type())
@@ -122,7 +148,7 @@ func (c *Container) Create(vp ValueProcessor) error {
 
 		// Invoke this constructor with our own result processor
 		vals = []reflect.Value{}
-		if err := c.Invoke(ctr, resProc); err != nil {
+		if err := c.invoke(ctr, resProc, caller); err != nil {
 			return err
 		}
 		// Cache all the values produced by this invocation.
@@ -135,6 +161,26 @@ func (c *Container) Create(vp ValueProcessor) error {
 	return nil
 }
 
+// Dependencies returns the dependency graph of the objects created by this
+// container. Each object type is mapped to the types of the objects in this
+// container it directly depends on. Dependencies that are provided by the
+// ancestors of the container are not included. The graph is complete only
+// after a successful Create.
+func (c *Container) Dependencies() map[reflect.Type][]reflect.Type {
+	deps := make(map[reflect.Type][]reflect.Type, len(c.objTable))
+	for t := range c.objTable {
+		ts := []reflect.Type{}
+		for _, k := range c.dag.Dependencies(t) {
+			d := k.(reflect.Type)
+			if _, ok := c.objTable[d]; ok {
+				ts = append(ts, d)
+			}
+		}
+		deps[t] = ts
+	}
+	return deps
+}
+
 // buildArgs builds the arguments required by the constructor by looking
 // up the object table.
 func (c *Container) buildArgs(ctrType reflect.Type) ([]reflect.Value, error) {
@@ -54,11 +54,59 @@ func TestContainer(t *testing.T) {
 			})
 			So(e, ShouldBeNil)
 		})
+		Convey("create with a caller", func() {
+			So(c.Add(func() *testS1 { return &testS1{} }), ShouldBeNil)
+			So(c.Add(func(*testS1) *testS2 { return &testS2{} }), ShouldBeNil)
+			called := []reflect.Type{}
+			e := c.CreateWith(nil, func(ctr interface{}, call func() error) error {
+				called = append(called, reflect.TypeOf(ctr).Out(0))
+				return call()
+			})
+			So(e, ShouldBeNil)
+			So(called, ShouldResemble, []reflect.Type{reflect.TypeOf(&testS1{}), reflect.TypeOf(&testS2{})})
+			So(c.Invoke(func(*testS2) {}, nil), ShouldBeNil)
+		})
+		Convey("create with a failing caller discards the values", func() {
+			So(c.Add(func() *testS1 { return &testS1{} }), ShouldBeNil)
+			e := c.CreateWith(nil, func(ctr interface{}, call func() error) error {
+				return errors.New("test")
+			})
+			So(e, ShouldBeError)
+			So(c.Invoke(func(*testS1) {}, nil), ShouldBeError)
+		})
+		Convey("can create several constructors returning errors", func() {
+			So(c.Add(func() (*testS1, error) { return &testS1{}, nil }), ShouldBeNil)
+			So(c.Add(func(*testS1) (*testS2, error) { return &testS2{}, nil }), ShouldBeNil)
+			So(c.Create(nil), ShouldBeNil)
+			So(c.Invoke(func(*testS2) {}, nil), ShouldBeNil)
+		})
 		Convey("can add constructors out of order and still construct", func() {
 			So(c.Add(func(*testS1) int { return 0 }), ShouldBeNil)
 			So(c.Add(func() *testS1 { return &testS1{} }), ShouldBeNil)
 			So(c.Create(nil), ShouldBeNil)
 		})
+		Convey("can get the dependencies of created objects", func() {
+			So(c.Add(func(*testS1) *testS2 { return &testS2{} }), ShouldBeNil)
+			So(c.Add(func() *testS1 { return &testS1{} }), ShouldBeNil)
+			So(c.Add(func(*testS1, *testS2) *testS3 { return &testS3{} }), ShouldBeNil)
+			So(c.Create(nil), ShouldBeNil)
+			t1 := reflect.TypeOf(testS1{})
+			t2 := reflect.TypeOf(testS2{})
+			t3 := reflect.TypeOf(testS3{})
+			deps := c.Dependencies()
+			So(deps, ShouldHaveLength, 3)
+			So(deps[t1], ShouldBeEmpty)
+			So(deps[t2], ShouldResemble, []reflect.Type{t1})
+			So(deps[t3], ShouldResemble, []reflect.Type{t1, t2})
+
+			// Dependencies provided by the parent are not included
+			cc := New(c)
+			So(cc.Add(func(*testS1) int { return 0 }), ShouldBeNil)
+			So(cc.Create(nil), ShouldBeNil)
+			So(cc.Dependencies(), ShouldResemble, map[reflect.Type][]reflect.Type{
+				reflect.TypeOf(0): {},
+			})
+		})
 		Convey("create with a error value processor", func() {
 			So(c.Add(func() *testS1 { return &testS1{} }), ShouldBeNil)
 			e := c.Create(
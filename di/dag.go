@@ -43,6 +43,10 @@ type Graph interface {
 	// the vertex is not present in the graph
 	SetValue(Key, Value) error
 
+	// Dependencies returns the keys of the vertices that the vertex specified by the key
+	// directly depends on. It returns nil if the vertex is not present in the graph.
+	Dependencies(Key) []Key
+
 	// Sort returns all the vertex entries in the dependency order. Vertices are ordered in
 	// such a way that a vertex's dependencies will always preseed itself.
 	Sort() []Vertex
@@ -53,6 +57,7 @@ func NewDAG() Graph {
 	return &dag{
 		graph.New(graph.Directed),
 		make(map[Key]graph.Node, 0),
+		make(map[Key][]Key, 0),
 	}
 }
 
@@ -64,10 +69,12 @@ type Vertex struct {
 
 // dag is a Graph which has an internal graph.Graph inside itself handling
 // nodes creation and making edges. It also has a map of all vertices which associates
-// the key to a graph.Node that is holding their corresponding component.
+// the key to a graph.Node that is holding their corresponding component. The
+// direct dependencies of each vertex are tracked by their keys.
 type dag struct {
 	graph    *graph.Graph
 	vertices map[Key]graph.Node
+	deps     map[Key][]Key
 }
 
 func (dg *dag) AddVertex(key Key, val Value) error {
@@ -87,6 +94,10 @@ func (dg *dag) RemoveVertex(key Key) error {
 	}
 	dg.graph.RemoveNode(&v)
 	delete(dg.vertices, key)
+	delete(dg.deps, key)
+	for k, deps := range dg.deps {
+		dg.deps[k] = removeKey(deps, key)
+	}
 	return nil
 }
 
@@ -121,8 +132,8 @@ func (dg *dag) addDep(srcObj graph.Node, node Key, dependency Key) error {
 		dg.graph.RemoveEdge(dstObj, srcObj)
 		return fmt.Errorf("edge from %s to %s makes a cycle", node, dependency)
 	}
+	dg.deps[node] = append(removeKey(dg.deps[node], dependency), dependency)
 	return nil
-
 }
 
 // Return the vertex by its key if exists else return nil.
@@ -144,6 +155,16 @@ func (dg *dag) SetValue(v Key, val Value) error {
 	return fmt.Errorf("key %s does not exist", v)
 }
 
+// Return the direct dependencies of the vertex if exists else return nil.
+func (dg *dag) Dependencies(v Key) []Key {
+	if _, ok := dg.vertices[v]; !ok {
+		return nil
+	}
+	deps := make([]Key, len(dg.deps[v]))
+	copy(deps, dg.deps[v])
+	return deps
+}
+
 // A sorted traversal of this graph will guarantee the
 // dependency order. This means A (node) depends on B (dependency) then
 // the sorted traversal will always return B before A.
@@ -171,3 +192,14 @@ func isAcyclic(dg *dag) bool {
 	}
 	return true
 }
+
+// removeKey returns the keys without the specified key.
+func removeKey(keys []Key, key Key) []Key {
+	out := keys[:0]
+	for _, k := range keys {
+		if k != key {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -30,6 +30,11 @@ func TestDag(t *testing.T) {
 		So(dag.AddDependencies("shirt", "shirt"), ShouldBeError)
 		So(dag.AddDependencies("shirt", "jacket"), ShouldBeError)
 
+		// Check the direct dependencies of the vertices
+		So(dag.Dependencies("jacket"), ShouldResemble, []Key{"tie", "belt"})
+		So(dag.Dependencies("pants"), ShouldBeEmpty)
+		So(dag.Dependencies("unknown_key"), ShouldBeNil)
+
 		// Check if the vertex can be retrieved
 		So(dag.GetValue("shirt"), ShouldEqual, 1)
 		So(dag.GetValue("unknown_key"), ShouldBeNil)
@@ -51,6 +56,8 @@ func TestDag(t *testing.T) {
 
 		// Add and remove a vertex
 		So(dag.AddVertex("hat", 100), ShouldBeNil)
+		So(dag.AddDependencies("hat", "jacket"), ShouldBeNil)
+		So(dag.Dependencies("hat"), ShouldResemble, []Key{"jacket"})
 		So(dag.RemoveVertex("hat"), ShouldBeNil)
 		So(dag.GetValue("hat"), ShouldBeNil)
 		So(dag.RemoveVertex("unknown_thing"), ShouldBeError)
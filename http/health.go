@@ -0,0 +1,93 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/anuvu/cube/component"
+)
+
+const (
+	// LivenessPath is the url of the liveness endpoint.
+	LivenessPath = "/livez"
+	// ReadinessPath is the url of the readiness endpoint.
+	ReadinessPath = "/readyz"
+	// HealthPath is the url of the health endpoint.
+	HealthPath = "/healthz"
+)
+
+// HealthEndpoints exposes the lifecycle state and the health of the component
+// group hierarchy on the http server.
+//
+// The liveness endpoint fails only if the group hierarchy failed. The readiness
+// endpoint succeeds only when the whole group hierarchy is started and the
+// server is not shutting down. The health endpoint returns the health report of
+// the group hierarchy and fails if any component is unhealthy.
+type HealthEndpoints struct {
+	ctx  component.Context
+	root component.Group
+}
+
+type stateResponse struct {
+	State string `json:"state"`
+	Live  bool   `json:"live"`
+	Ready bool   `json:"ready"`
+}
+
+// NewHealthEndpoints registers the liveness, readiness and health endpoints on
+// the http server. Add it to a group to opt-in to the endpoints.
+func NewHealthEndpoints(ctx component.Context, root component.Group, s Server) *HealthEndpoints {
+	he := &HealthEndpoints{ctx: ctx, root: root}
+	s.Register(LivenessPath, http.HandlerFunc(he.serveLiveness))
+	s.Register(ReadinessPath, http.HandlerFunc(he.serveReadiness))
+	s.Register(HealthPath, http.HandlerFunc(he.serveHealth))
+	return he
+}
+
+// IsLive returns false if the group hierarchy failed.
+func (he *HealthEndpoints) IsLive() bool {
+	return he.root.State() != component.StateFailed
+}
+
+// IsReady returns true if the group hierarchy is started and the server is
+// not shutting down.
+func (he *HealthEndpoints) IsReady() bool {
+	return he.root.State() == component.StateStarted && he.ctx.Ctx().Err() == nil
+}
+
+func (he *HealthEndpoints) state() *stateResponse {
+	return &stateResponse{
+		State: he.root.State().String(),
+		Live:  he.IsLive(),
+		Ready: he.IsReady(),
+	}
+}
+
+func (he *HealthEndpoints) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	s := he.state()
+	he.writeJSON(w, s.Live, s)
+}
+
+func (he *HealthEndpoints) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	s := he.state()
+	he.writeJSON(w, s.Ready, s)
+}
+
+func (he *HealthEndpoints) serveHealth(w http.ResponseWriter, r *http.Request) {
+	report := he.root.Health()
+	he.writeJSON(w, report.Status != component.HealthUnhealthy, report)
+}
+
+// writeJSON writes the value as a json response, the status code is OK if
+// ok is true else service unavailable.
+func (he *HealthEndpoints) writeJSON(w http.ResponseWriter, ok bool, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		he.ctx.Log().Info().Error(err).Msg("error writing response")
+	}
+}
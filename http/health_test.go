@@ -0,0 +1,85 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/anuvu/cube/component"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const healthPort = 8990
+
+type sickCmp struct {
+	sick bool
+}
+
+func (s *sickCmp) IsHealthy(ctx component.Context) bool { return !s.sick }
+
+func get(path string, v interface{}) int {
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", healthPort, path))
+	So(err, ShouldBeNil)
+	defer resp.Body.Close()
+	So(resp.Header.Get("Content-Type"), ShouldEqual, "application/json")
+	So(json.NewDecoder(resp.Body).Decode(v), ShouldBeNil)
+	return resp.StatusCode
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"http.test", "--config.mem", fmt.Sprintf(`{"http": {"port": %d}}`, healthPort)}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we start a group with health endpoints", t, func() {
+		root := component.New("root")
+		grp := root.New("http")
+		sick := &sickCmp{}
+		So(grp.Add(New), ShouldBeNil)
+		So(grp.Add(NewHealthEndpoints), ShouldBeNil)
+		So(grp.Add(func() *sickCmp { return sick }), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+
+		// Not ready until the whole hierarchy is started
+		grp.Invoke(func(he *HealthEndpoints) {
+			So(he.IsLive(), ShouldBeTrue)
+			So(he.IsReady(), ShouldBeFalse)
+		})
+		So(root.Start(), ShouldBeNil)
+		defer root.Stop()
+
+		grp.Invoke(func(he *HealthEndpoints, shut component.ServerShutdown) {
+			Convey("the hierarchy should be live, ready and healthy", func() {
+				s := &stateResponse{}
+				So(get(LivenessPath, s), ShouldEqual, http.StatusOK)
+				So(s, ShouldResemble, &stateResponse{"started", true, true})
+				So(get(ReadinessPath, s), ShouldEqual, http.StatusOK)
+				r := &component.HealthReport{}
+				So(get(HealthPath, r), ShouldEqual, http.StatusOK)
+				So(r.Status, ShouldEqual, component.HealthHealthy)
+				So(r.Groups["http"].Components["*http.sickCmp"].Status, ShouldEqual, component.HealthHealthy)
+			})
+
+			Convey("unhealthy components should fail the health endpoint", func() {
+				sick.sick = true
+				r := &component.HealthReport{}
+				So(get(HealthPath, r), ShouldEqual, http.StatusServiceUnavailable)
+				So(r.Groups["http"].Components["*http.sickCmp"].Status, ShouldEqual, component.HealthUnhealthy)
+				So(get(ReadinessPath, &stateResponse{}), ShouldEqual, http.StatusOK)
+			})
+
+			Convey("readiness should flip as soon as the shutdown begins", func() {
+				shut()
+				s := &stateResponse{}
+				So(get(ReadinessPath, s), ShouldEqual, http.StatusServiceUnavailable)
+				So(s, ShouldResemble, &stateResponse{"started", true, false})
+				So(get(LivenessPath, s), ShouldEqual, http.StatusOK)
+				So(he.IsReady(), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,219 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/anuvu/cube/component"
+)
+
+// Middleware wraps a handler to add cross-cutting behavior to the requests.
+type Middleware func(http.Handler) http.Handler
+
+// Orders of the built-in middleware. Middleware with a lower order wraps the
+// middleware with a higher order, hence runs first on the requests.
+const (
+	OrderRecovery  = 100
+	OrderRequestID = 200
+	OrderLogging   = 300
+	OrderTimeout   = 400
+)
+
+// RequestIDHeader is the header carrying the request ID.
+const RequestIDHeader = "X-Request-ID"
+
+// middleware is a global middleware registered with the server.
+type middleware struct {
+	order int
+	name  string
+	m     Middleware
+}
+
+// chain wraps the handler with the middleware sorted by their order and names,
+// so that the chain does not depend on the order the components register them.
+func chain(h http.Handler, mws []middleware) http.Handler {
+	sorted := append([]middleware{}, mws...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].order != sorted[j].order {
+			return sorted[i].order < sorted[j].order
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	for i := len(sorted) - 1; i >= 0; i-- {
+		h = sorted[i].m(h)
+	}
+	return h
+}
+
+// wrap wraps the handler with the middleware, the first middleware runs first.
+func wrap(h http.Handler, mws []Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// StatusWriter wraps the writer of a response to capture the status code
+// written by the handler, for the middleware reporting on the responses. It
+// forwards the flushes and the hijacks to the wrapped writer.
+type StatusWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+// NewStatusWriter wraps the writer of the response.
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w}
+}
+
+// Status returns the status code written by the handler, http.StatusOK if the
+// handler did not write one.
+func (w *StatusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Written returns true once the response is committed to the client.
+func (w *StatusWriter) Written() bool {
+	return w.status != 0 || w.hijacked
+}
+
+// WriteHeader captures the status code and writes it to the wrapped writer.
+func (w *StatusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the data to the wrapped writer, with the implicit http.StatusOK
+// status code if none was written.
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the buffered data to the client if the wrapped writer supports
+// it.
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection if the wrapped writer supports it.
+func (w *StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", w.ResponseWriter)
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Logging logs every request with its status and duration through the logger
+// of the group.
+func Logging(ctx component.Context) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := NewStatusWriter(w)
+			h.ServeHTTP(sw, r)
+			ctx.Log().Info().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("status", strconv.Itoa(sw.Status())).
+				Str("duration", time.Since(start).String()).
+				Str("request_id", RequestID(r.Context())).
+				Msg("served request")
+		})
+	}
+}
+
+// Recovery recovers from the panics in the handlers, logs them through the
+// logger of the group and responds with an internal server error unless the
+// handler already responded.
+func Recovery(ctx component.Context) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := NewStatusWriter(w)
+			defer func() {
+				if p := recover(); p != nil {
+					if p == http.ErrAbortHandler {
+						panic(p)
+					}
+					ctx.Log().Info().
+						Error(fmt.Errorf("%v", p)).
+						Str("method", r.Method).
+						Str("path", r.URL.Path).
+						Str("request_id", RequestID(r.Context())).
+						Msg("recovered from panic")
+					if !sw.Written() {
+						http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					}
+				}
+			}()
+			h.ServeHTTP(sw, r)
+		})
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestIDs assigns an ID to every request, retrievable with RequestID. The ID
+// provided by the client in the X-Request-ID header is retained, else a new
+// one is generated. The ID is returned in the X-Request-ID response header.
+func RequestIDs() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+// RequestID returns the ID of the request, or an empty string if the request
+// was not served through the RequestIDs middleware.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Timeout cancels the context of the requests that do not complete within the
+// timeout and responds with a service unavailable error.
+func Timeout(d time.Duration) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.TimeoutHandler(h, d, "request timed out")
+	}
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zlog"
+
+	"github.com/anuvu/cube/component"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func tracer(trace *[]string, name string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*trace = append(*trace, name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func serve(s Server, method, url string, hdr http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, nil)
+	for k := range hdr {
+		r.Header.Set(k, hdr.Get(k))
+	}
+	s.(http.Handler).ServeHTTP(w, r)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	Convey("With an http server", t, func() {
+		ctx := component.RootContext(zlog.New("http.test"))
+		s := New(ctx)
+		trace := []string{}
+
+		Convey("global middleware should be ordered by order and name", func() {
+			s.Use(20, "b", tracer(&trace, "b"))
+			s.Use(20, "a", tracer(&trace, "a"))
+			s.Use(10, "c", tracer(&trace, "c"))
+			s.Register("/foo", testHandler{}, tracer(&trace, "route1"), tracer(&trace, "route2"))
+			s.Register("/bar", testHandler{})
+
+			w := serve(s, "GET", "/foo", nil)
+			So(w.Body.String(), ShouldEqual, msg)
+			So(trace, ShouldResemble, []string{"c", "a", "b", "route1", "route2"})
+
+			trace = trace[:0]
+			serve(s, "GET", "/bar", nil)
+			So(trace, ShouldResemble, []string{"c", "a", "b"})
+
+			Convey("and replaced by name", func() {
+				s.Use(5, "b", tracer(&trace, "new-b"))
+				trace = trace[:0]
+				serve(s, "GET", "/bar", nil)
+				So(trace, ShouldResemble, []string{"new-b", "c", "a"})
+			})
+		})
+
+		Convey("recovery should turn panics into internal server errors", func() {
+			s.Use(OrderRecovery, "recovery", Recovery(ctx))
+			s.Use(OrderLogging, "logging", Logging(ctx))
+			s.Register("/panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			}))
+
+			w := serve(s, "GET", "/panic", nil)
+			So(w.Code, ShouldEqual, http.StatusInternalServerError)
+
+			Convey("unless the handler already responded", func() {
+				s.Register("/partial", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusAccepted)
+					w.Write([]byte("partial"))
+					panic("boom")
+				}))
+
+				w := serve(s, "GET", "/partial", nil)
+				So(w.Code, ShouldEqual, http.StatusAccepted)
+				So(w.Body.String(), ShouldEqual, "partial")
+			})
+		})
+
+		Convey("wrapped writers should support flushes and hijacks", func() {
+			s.Use(OrderRecovery, "recovery", Recovery(ctx))
+			s.Use(OrderLogging, "logging", Logging(ctx))
+			s.Register("/flush", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+			}))
+			s.Register("/hijack", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, rw, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					panic(err)
+				}
+				defer conn.Close()
+				rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+				rw.Flush()
+			}))
+
+			w := serve(s, "GET", "/flush", nil)
+			So(w.Flushed, ShouldBeTrue)
+
+			ts := httptest.NewServer(s.(http.Handler))
+			defer ts.Close()
+			resp, err := http.Get(ts.URL + "/hijack")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "hijacked")
+		})
+
+		Convey("request IDs should be generated or retained", func() {
+			ids := []string{}
+			s.Use(OrderRequestID, "request-id", RequestIDs())
+			s.Register("/id", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ids = append(ids, RequestID(r.Context()))
+			}))
+
+			w := serve(s, "GET", "/id", nil)
+			So(ids[0], ShouldHaveLength, 32)
+			So(w.Header().Get(RequestIDHeader), ShouldEqual, ids[0])
+
+			w = serve(s, "GET", "/id", http.Header{"X-Request-Id": {"abc"}})
+			So(ids[1], ShouldEqual, "abc")
+			So(w.Header().Get(RequestIDHeader), ShouldEqual, "abc")
+		})
+
+		Convey("slow requests should time out", func() {
+			s.Use(OrderTimeout, "timeout", Timeout(10*time.Millisecond))
+			canceled := make(chan bool, 1)
+			s.Register("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+					canceled <- true
+				case <-time.After(time.Second):
+					canceled <- false
+				}
+			}))
+
+			w := serve(s, "GET", "/slow", nil)
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(<-canceled, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,217 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Router registers handlers for HTTP methods and path patterns. The segments
+// of a pattern enclosed in braces, as in "/users/{id}", are path parameters
+// that match any single segment of the request path. Literal segments take
+// precedence over path parameters.
+//
+// Requests matching the path of a route but not its methods are responded
+// with 405 Method Not Allowed and the Allow header listing the methods of the
+// path. GET routes serve HEAD requests too.
+//
+// Like http.ServeMux, the routers panic if a route is registered twice for the
+// same method and pattern. Patterns differing only by the names of their path
+// parameters are the same pattern.
+type Router interface {
+	// Handle registers the handler for the method and the pattern, wrapped by
+	// the middleware of the route.
+	Handle(method, pattern string, h http.Handler, mws ...Middleware)
+
+	// HandleFunc registers the handler function for the method and the pattern.
+	HandleFunc(method, pattern string, f http.HandlerFunc, mws ...Middleware)
+
+	// Group returns a router whose routes share the prefix and are wrapped by
+	// the middleware of the group.
+	Group(prefix string, mws ...Middleware) Router
+}
+
+type paramsKey struct{}
+
+// Params returns the path parameters of the request served by a Router.
+func Params(ctx context.Context) map[string]string {
+	p, _ := ctx.Value(paramsKey{}).(map[string]string)
+	return p
+}
+
+// Param returns the path parameter of the request served by a Router, or an
+// empty string if the route does not have the parameter.
+func Param(ctx context.Context, name string) string {
+	return Params(ctx)[name]
+}
+
+type route struct {
+	method   string
+	segments []string
+	handler  http.Handler
+}
+
+// match returns the path parameters if the path segments match the route.
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, s := range rt.segments {
+		if name, ok := paramName(s); ok {
+			params[name] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// same returns true if the routes have the same method and match the same
+// paths.
+func (rt *route) same(other *route) bool {
+	if rt.method != other.method || len(rt.segments) != len(other.segments) {
+		return false
+	}
+	for i, s := range rt.segments {
+		_, p1 := paramName(s)
+		_, p2 := paramName(other.segments[i])
+		if p1 != p2 || (!p1 && s != other.segments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific returns true if the route has a literal segment where the other
+// route has a path parameter, before any segment where the reverse holds.
+func (rt *route) moreSpecific(other *route) bool {
+	for i, s := range rt.segments {
+		_, p1 := paramName(s)
+		_, p2 := paramName(other.segments[i])
+		if p1 != p2 {
+			return p2
+		}
+	}
+	return false
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// router dispatches the requests to the routes, the requests not matching any
+// route are served by the fallback handler.
+type router struct {
+	lock     sync.RWMutex
+	routes   []*route
+	fallback http.Handler
+}
+
+func newRouter(fallback http.Handler) *router {
+	return &router{fallback: fallback}
+}
+
+func (rr *router) Handle(method, pattern string, h http.Handler, mws ...Middleware) {
+	rt := &route{
+		method:   strings.ToUpper(method),
+		segments: splitPath(pattern),
+		handler:  wrap(h, mws),
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	for _, other := range rr.routes {
+		if rt.same(other) {
+			panic("http: multiple registrations for " + rt.method + " " + pattern)
+		}
+	}
+	rr.routes = append(rr.routes, rt)
+}
+
+func (rr *router) HandleFunc(method, pattern string, f http.HandlerFunc, mws ...Middleware) {
+	rr.Handle(method, pattern, f, mws...)
+}
+
+func (rr *router) Group(prefix string, mws ...Middleware) Router {
+	return &routeGroup{rr, strings.TrimRight(prefix, "/"), mws}
+}
+
+func (rr *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	var best *route
+	var bestParams map[string]string
+	allowed := map[string]bool{}
+
+	rr.lock.RLock()
+	for _, rt := range rr.routes {
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		allowed[rt.method] = true
+		if rt.method == http.MethodGet {
+			allowed[http.MethodHead] = true
+		}
+		if rt.method != r.Method && !(rt.method == http.MethodGet && r.Method == http.MethodHead) {
+			continue
+		}
+		// Prefer the more specific routes, and the routes of the request method
+		// over the GET routes serving HEAD requests
+		if best == nil || rt.moreSpecific(best) ||
+			(!best.moreSpecific(rt) && rt.method == r.Method && best.method != r.Method) {
+			best, bestParams = rt, params
+		}
+	}
+	rr.lock.RUnlock()
+
+	if best != nil {
+		best.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), paramsKey{}, bestParams)))
+		return
+	}
+	if len(allowed) > 0 {
+		methods := make([]string, 0, len(allowed))
+		for m := range allowed {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	rr.fallback.ServeHTTP(w, r)
+}
+
+// routeGroup registers the routes with the router under the prefix, wrapped by
+// the middleware of the group.
+type routeGroup struct {
+	router *router
+	prefix string
+	mws    []Middleware
+}
+
+func (rg *routeGroup) Handle(method, pattern string, h http.Handler, mws ...Middleware) {
+	all := append(append([]Middleware{}, rg.mws...), mws...)
+	rg.router.Handle(method, rg.prefix+"/"+strings.TrimLeft(pattern, "/"), h, all...)
+}
+
+func (rg *routeGroup) HandleFunc(method, pattern string, f http.HandlerFunc, mws ...Middleware) {
+	rg.Handle(method, pattern, f, mws...)
+}
+
+func (rg *routeGroup) Group(prefix string, mws ...Middleware) Router {
+	all := append(append([]Middleware{}, rg.mws...), mws...)
+	return &routeGroup{rg.router, rg.prefix + "/" + strings.Trim(prefix, "/"), all}
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/anuvu/zlog"
+
+	"github.com/anuvu/cube/component"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func echo(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %v", name, Params(r.Context()))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	Convey("With routes registered on an http server", t, func() {
+		ctx := component.RootContext(zlog.New("http.test"))
+		s := New(ctx)
+		trace := []string{}
+		s.HandleFunc("GET", "/users/{id}", echo("get"))
+		s.HandleFunc("DELETE", "/users/{id}", echo("delete"))
+		s.HandleFunc("GET", "/users/me", echo("me"))
+		s.HandleFunc("GET", "/users/{id}/posts/{post}", echo("post"))
+		s.Register("/legacy/", testHandler{})
+
+		api := s.Group("/api/", tracer(&trace, "api"))
+		v1 := api.Group("v1", tracer(&trace, "v1"))
+		v1.HandleFunc("POST", "/items", echo("create"), tracer(&trace, "route"))
+
+		Convey("requests should be dispatched by method and path", func() {
+			So(serve(s, "GET", "/users/42", nil).Body.String(), ShouldEqual, "get map[id:42]")
+			So(serve(s, "DELETE", "/users/42/", nil).Body.String(), ShouldEqual, "delete map[id:42]")
+			So(serve(s, "GET", "/users/7/posts/hello", nil).Body.String(), ShouldEqual, "post map[id:7 post:hello]")
+		})
+
+		Convey("path parameters should be retrievable from the context", func() {
+			s.HandleFunc("PUT", "/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, Param(r.Context(), "id"), Param(r.Context(), "missing"))
+			})
+			So(serve(s, "PUT", "/users/42", nil).Body.String(), ShouldEqual, "42")
+		})
+
+		Convey("literal segments should take precedence over parameters", func() {
+			So(serve(s, "GET", "/users/me", nil).Body.String(), ShouldEqual, "me map[]")
+			So(serve(s, "DELETE", "/users/me", nil).Body.String(), ShouldEqual, "delete map[id:me]")
+		})
+
+		Convey("GET routes should serve HEAD requests", func() {
+			So(serve(s, "HEAD", "/users/42", nil).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("unsupported methods should be responded with 405", func() {
+			w := serve(s, "POST", "/users/42", nil)
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			So(w.Header().Get("Allow"), ShouldEqual, "DELETE, GET, HEAD")
+		})
+
+		Convey("route groups should share the prefix and the middleware", func() {
+			w := serve(s, "POST", "/api/v1/items", nil)
+			So(w.Body.String(), ShouldEqual, "create map[]")
+			So(trace, ShouldResemble, []string{"api", "v1", "route"})
+			So(serve(s, "POST", "/v1/items", nil).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("duplicate routes should panic at registration", func() {
+			So(func() { s.HandleFunc("get", "/users/{id}", echo("again")) }, ShouldPanic)
+			So(func() { s.HandleFunc("GET", "/users/{uid}/", echo("again")) }, ShouldPanic)
+			So(func() { v1.HandleFunc("POST", "items", echo("again")) }, ShouldPanic)
+			So(func() { s.HandleFunc("PUT", "/users/{id}", echo("put")) }, ShouldNotPanic)
+			So(serve(s, "GET", "/users/42", nil).Body.String(), ShouldEqual, "get map[id:42]")
+		})
+
+		Convey("unmatched requests should fall back to the registered handlers", func() {
+			So(serve(s, "GET", "/legacy/foo", nil).Body.String(), ShouldEqual, msg)
+			So(serve(s, "GET", "/users", nil).Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
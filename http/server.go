@@ -1,48 +1,128 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/anuvu/cube/component"
 	"github.com/anuvu/cube/config"
 )
 
+// DefaultDrainTimeout is the time given to the in-flight requests to complete
+// on shutdown, if the drain timeout is not configured.
+const DefaultDrainTimeout = 10 * time.Second
+
 // Server is the object through which people can register HTTP servers.
+//
+// The routes registered through the Router take precedence over the handlers
+// registered with Register.
+//
+// Register registers the handler for the URL pattern, wrapped by the route
+// middleware. The first middleware runs first on the requests.
+//
+// Use adds a middleware to all the routes of the server. The global middleware
+// are ordered by their order and then their names, and wrap the route
+// middleware. Registering a middleware with an existing name replaces it.
 type Server interface {
-	Register(string, http.Handler)
+	Router
+	Register(url string, h http.Handler, mws ...Middleware)
+	Use(order int, name string, m Middleware)
 }
 
 type server struct {
-	config  *configuration
-	mux     *http.ServeMux
-	server  http.Server
-	running int32
+	config       *configuration
+	router       *router
+	mux          *http.ServeMux
+	lock         sync.RWMutex
+	middleware   []middleware
+	handler      http.Handler
+	server       *http.Server
+	drainTimeout time.Duration
+	tlsConfig    *tls.Config
+	shutOnce     *sync.Once
+	shutErr      error
+	done         chan struct{}
+	running      int32
 }
 
 // configuration defines the configurable parameters of http server
 type configuration struct {
 	config.BaseConfig
+	// Listen host name or address, the server listens on all the interfaces
+	// if it is empty
+	Host string `json:"host"`
 	// Listen port
 	Port int `json:"port"`
+	// TLS parameters, the server serves plain HTTP if they are not specified
+	TLS *tlsConfiguration `json:"tls"`
+	// Time given to the in-flight requests to complete on shutdown, as a
+	// duration string such as "30s"
+	DrainTimeout string `json:"drain_timeout"`
 }
 
-// New creates a new HTTP server
+// New creates a new HTTP server configured under the "http" key. See Servers
+// for running multiple HTTP servers in a process.
 func New(ctx component.Context) Server {
+	return newServer("http")
+}
+
+func newServer(key config.Key) *server {
 	cfg := &configuration{
-		config.BaseConfig{ConfigKey: "http"},
-		0,
+		BaseConfig: config.BaseConfig{ConfigKey: key},
+		Host:       "localhost",
+	}
+	s := &server{
+		config:       cfg,
+		mux:          http.NewServeMux(),
+		drainTimeout: DefaultDrainTimeout,
 	}
-	return &server{
-		config: cfg,
-		mux:    http.NewServeMux(),
+	s.router = newRouter(s.mux)
+	s.handler = s.router
+	return s
+}
+
+func (s *server) Register(url string, h http.Handler, mws ...Middleware) {
+	s.mux.Handle(url, wrap(h, mws))
+}
+
+func (s *server) Handle(method, pattern string, h http.Handler, mws ...Middleware) {
+	s.router.Handle(method, pattern, h, mws...)
+}
+
+func (s *server) HandleFunc(method, pattern string, f http.HandlerFunc, mws ...Middleware) {
+	s.router.HandleFunc(method, pattern, f, mws...)
+}
+
+func (s *server) Group(prefix string, mws ...Middleware) Router {
+	return s.router.Group(prefix, mws...)
+}
+
+func (s *server) Use(order int, name string, m Middleware) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mws := []middleware{}
+	for _, mw := range s.middleware {
+		if mw.name != name {
+			mws = append(mws, mw)
+		}
 	}
+	s.middleware = append(mws, middleware{order, name, m})
+	s.handler = chain(s.router, s.middleware)
 }
 
-func (s *server) Register(url string, h http.Handler) {
-	s.mux.Handle(url, h)
+// ServeHTTP serves the requests through the global middleware chain.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.RLock()
+	h := s.handler
+	s.lock.RUnlock()
+	h.ServeHTTP(w, r)
 }
 
 func (s *server) Config() config.Config {
@@ -50,28 +130,80 @@ func (s *server) Config() config.Config {
 }
 
 func (s *server) Configure(ctx component.Context) error {
+	if s.config.DrainTimeout != "" {
+		d, err := time.ParseDuration(s.config.DrainTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid drain timeout: %v", err)
+		}
+		s.drainTimeout = d
+	}
+
+	if s.config.TLS != nil {
+		cfg, err := newTLSConfig(ctx, s.config.TLS)
+		if err != nil {
+			return err
+		}
+		s.tlsConfig = cfg
+	}
 	return nil
 }
 
 func (s *server) Start(ctx component.Context) error {
-	addr := fmt.Sprintf("localhost:%d", s.config.Port)
-	s.server = http.Server{Addr: addr, Handler: s.mux}
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+	if s.tlsConfig != nil {
+		l = tls.NewListener(l, s.tlsConfig)
+	}
+	s.server = &http.Server{Addr: addr, Handler: s, TLSConfig: s.tlsConfig}
+	s.shutOnce = &sync.Once{}
+	s.done = make(chan struct{})
 	atomic.AddInt32(&s.running, 1)
 	go func() {
-		if err := s.server.Serve(l); err != nil {
+		if err := s.server.Serve(l); err != http.ErrServerClosed {
 			ctx.Log().Info().Error(err).Msg("error starting server")
 		}
 	}()
+
+	// Close idle connections as soon as the shutdown is initiated, so that the
+	// clients move to other instances while the readiness probes keep reporting
+	// the shutdown until the server is stopped
+	go func() {
+		select {
+		case <-ctx.Ctx().Done():
+			s.server.SetKeepAlivesEnabled(false)
+		case <-s.done:
+		}
+	}()
 	return nil
 }
 
 func (s *server) Stop(ctx component.Context) error {
-	atomic.AddInt32(&s.running, -1)
-	return s.server.Close()
+	if s.server == nil {
+		return nil
+	}
+	return s.shutdown(ctx)
+}
+
+// shutdown gracefully shuts down the server, waiting for the in-flight requests
+// to complete. If they do not complete within the drain timeout the remaining
+// connections are closed.
+func (s *server) shutdown(ctx component.Context) error {
+	s.shutOnce.Do(func() {
+		defer close(s.done)
+		atomic.AddInt32(&s.running, -1)
+
+		dctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+		defer cancel()
+		if err := s.server.Shutdown(dctx); err != nil {
+			ctx.Log().Info().Error(err).Str("timeout", s.drainTimeout.String()).Msg("closing connections that failed to drain")
+			s.server.Close()
+			s.shutErr = fmt.Errorf("connections failed to drain within %s", s.drainTimeout)
+		}
+	})
+	return s.shutErr
 }
 
 func (s *server) IsHealthy(ctx component.Context) bool {
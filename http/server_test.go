@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/anuvu/zlog"
 
@@ -66,3 +67,110 @@ func TestBadPort(t *testing.T) {
 		So(s.Start(ctx), ShouldNotBeNil)
 	})
 }
+
+type slowHandler struct {
+	started chan struct{}
+	delay   time.Duration
+}
+
+func (sh slowHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	close(sh.started)
+	time.Sleep(sh.delay)
+	_, err := w.Write([]byte(msg))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	Convey("http server drains in-flight requests on stop", t, func() {
+		ctx := component.RootContext(zlog.New("http.test"))
+		srv := New(ctx).(*server)
+		cfg := srv.Config().(*configuration)
+		cfg.Port = port
+		cfg.DrainTimeout = "1s"
+		So(srv.Configure(ctx), ShouldBeNil)
+		So(srv.drainTimeout, ShouldEqual, time.Second)
+		So(srv.Start(ctx), ShouldBeNil)
+
+		sh := slowHandler{make(chan struct{}), 200 * time.Millisecond}
+		srv.Register("/slow", sh)
+		body := make(chan string, 1)
+		go func() {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/slow", port))
+			if err != nil {
+				body <- err.Error()
+				return
+			}
+			bytes, _ := ioutil.ReadAll(resp.Body)
+			body <- string(bytes)
+		}()
+		<-sh.started
+
+		So(srv.Stop(ctx), ShouldBeNil)
+		So(<-body, ShouldEqual, msg)
+		So(srv.IsHealthy(ctx), ShouldBeFalse)
+
+		// Stopping again should be a no-op
+		So(srv.Stop(ctx), ShouldBeNil)
+	})
+
+	Convey("http server closes connections after the drain timeout", t, func() {
+		ctx := component.RootContext(zlog.New("http.test"))
+		srv := New(ctx).(*server)
+		cfg := srv.Config().(*configuration)
+		cfg.Port = port
+		cfg.DrainTimeout = "50ms"
+		So(srv.Configure(ctx), ShouldBeNil)
+		So(srv.Start(ctx), ShouldBeNil)
+
+		sh := slowHandler{make(chan struct{}), time.Second}
+		srv.Register("/slow", sh)
+		errc := make(chan error, 1)
+		go func() {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/slow", port))
+			if err == nil {
+				_, err = ioutil.ReadAll(resp.Body)
+			}
+			errc <- err
+		}()
+		<-sh.started
+
+		start := time.Now()
+		So(srv.Stop(ctx), ShouldNotBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(<-errc, ShouldNotBeNil)
+	})
+
+	Convey("http server closes connections once the shutdown is initiated", t, func() {
+		ctx := component.RootContext(zlog.New("http.test"))
+		srv := New(ctx).(*server)
+		cfg := srv.Config().(*configuration)
+		cfg.Port = port
+		So(srv.Configure(ctx), ShouldBeNil)
+		So(srv.Start(ctx), ShouldBeNil)
+		srv.Register("/foo", testHandler{})
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/foo", port))
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.Close, ShouldBeFalse)
+
+		ctx.(interface{ Shutdown() }).Shutdown()
+		for i := 0; i < 100 && !resp.Close; i++ {
+			time.Sleep(time.Millisecond)
+			resp, err = http.Get(fmt.Sprintf("http://localhost:%d/foo", port))
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+		}
+		So(resp.Close, ShouldBeTrue)
+		So(srv.Stop(ctx), ShouldBeNil)
+	})
+
+	Convey("http server with bad drain timeout", t, func() {
+		ctx := component.RootContext(zlog.New("http.test"))
+		srv := New(ctx).(*server)
+		srv.Config().(*configuration).DrainTimeout = "forever"
+		So(srv.Configure(ctx), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/anuvu/cube/component"
+	"github.com/anuvu/cube/config"
+)
+
+// Servers provides the named HTTP servers of a process, such as the public API,
+// the admin and the debug servers. Each server listens on its own port with its
+// own TLS parameters and middleware, and is configured under its name in the
+// configuration store, with the same parameters as the "http" key.
+//
+// Components get the servers by injecting Servers into their constructors. As
+// the container provides one object per type, a server is injected by its name
+// through a type of its own produced from Servers:
+//
+//	type AdminServer struct{ http.Server }
+//
+//	g.Add(func(ss http.Servers) (*AdminServer, error) {
+//		s, err := ss.Get("admin")
+//		return &AdminServer{s}, err
+//	})
+type Servers interface {
+	// Get returns the server with the name, or an error if there is no such
+	// server.
+	Get(name string) (Server, error)
+
+	// Names returns the names of the servers in the sorted order.
+	Names() []string
+}
+
+type servers struct {
+	store   config.Store
+	servers map[string]*server
+	started []string
+}
+
+// NewServers returns the constructor of the named HTTP servers, for adding to
+// a component group.
+//
+//	g.Add(http.NewServers("public", "admin"))
+func NewServers(names ...string) func(ctx component.Context, store config.Store) Servers {
+	return func(ctx component.Context, store config.Store) Servers {
+		ss := &servers{store: store, servers: map[string]*server{}}
+		for _, name := range names {
+			ss.servers[name] = newServer(config.Key(name))
+		}
+		return ss
+	}
+}
+
+func (ss *servers) Get(name string) (Server, error) {
+	if s, ok := ss.servers[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("no http server named %s", name)
+}
+
+func (ss *servers) Names() []string {
+	names := make([]string, 0, len(ss.servers))
+	for name := range ss.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Config returns an empty configuration, the configuration of each server is
+// loaded from the store on Configure.
+func (ss *servers) Config() config.Config {
+	return &config.BaseConfig{}
+}
+
+func (ss *servers) Configure(ctx component.Context) error {
+	for _, name := range ss.Names() {
+		s := ss.servers[name]
+		if err := ss.store.Get(s.config); err != nil {
+			return err
+		}
+		if err := s.Configure(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts all the servers, if a server fails to start the servers that
+// are already started are stopped.
+func (ss *servers) Start(ctx component.Context) error {
+	for _, name := range ss.Names() {
+		if err := ss.servers[name].Start(ctx); err != nil {
+			ss.Stop(ctx)
+			return err
+		}
+		ss.started = append(ss.started, name)
+	}
+	return nil
+}
+
+// Stop stops all the started servers in the reverse order, draining their
+// in-flight requests.
+func (ss *servers) Stop(ctx component.Context) error {
+	errs := component.MultiError{}
+	for i := len(ss.started) - 1; i >= 0; i-- {
+		if err := ss.servers[ss.started[i]].Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	ss.started = nil
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Health reports the servers that are not running in the details.
+func (ss *servers) Health(ctx component.Context) component.Health {
+	h := component.Health{Status: component.HealthHealthy}
+	for _, name := range ss.Names() {
+		if !ss.servers[name].IsHealthy(ctx) {
+			if h.Details == nil {
+				h.Details = map[string]interface{}{}
+			}
+			h.Details[name] = "not running"
+			h.Status = component.HealthUnhealthy
+		}
+	}
+	return h
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/anuvu/cube/component"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	publicPort = 8991
+	adminPort  = 8992
+)
+
+type apiCmp struct {
+	trace []string
+}
+
+type adminServer struct {
+	Server
+}
+
+func newAdminServer(servers Servers) (*adminServer, error) {
+	s, err := servers.Get("admin")
+	return &adminServer{s}, err
+}
+
+func newAPICmp(servers Servers, admin *adminServer) (*apiCmp, error) {
+	a := &apiCmp{}
+	public, err := servers.Get("public")
+	if err != nil {
+		return nil, err
+	}
+	public.HandleFunc("GET", "/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "public")
+	})
+	admin.Use(OrderLogging, "trace", tracer(&a.trace, "admin"))
+	admin.HandleFunc("GET", "/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "admin")
+	})
+	return a, nil
+}
+
+func getBody(port int, path string) string {
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+	So(err, ShouldBeNil)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	So(err, ShouldBeNil)
+	return string(b)
+}
+
+func TestServers(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	Convey("With named servers in a group", t, func() {
+		os.Args = []string{"http.test", "--config.mem", fmt.Sprintf(`{"public": {"port": %d}, "admin": {"port": %d}}`, publicPort, adminPort)}
+		root := component.New("root")
+		So(root.Add(NewServers("public", "admin")), ShouldBeNil)
+		So(root.Add(newAdminServer), ShouldBeNil)
+		So(root.Add(newAPICmp), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+
+		Convey("each server should be injectable and serve its own routes", func() {
+			So(root.Configure(), ShouldBeNil)
+			So(root.Start(), ShouldBeNil)
+			defer root.Stop()
+
+			root.Invoke(func(ss Servers, a *apiCmp) {
+				So(ss.Names(), ShouldResemble, []string{"admin", "public"})
+				s, err := ss.Get("debug")
+				So(s, ShouldBeNil)
+				So(err, ShouldBeError, "no http server named debug")
+				So(getBody(publicPort, "/hello"), ShouldEqual, "public")
+				So(a.trace, ShouldBeEmpty)
+				So(getBody(adminPort, "/hello"), ShouldEqual, "admin")
+				So(a.trace, ShouldResemble, []string{"admin"})
+			})
+			So(root.Health().Status, ShouldEqual, component.HealthHealthy)
+		})
+
+		Convey("servers should be configured under their own keys", func() {
+			os.Args = []string{"http.test", "--config.mem", fmt.Sprintf(`{"public": {"port": %d}}`, publicPort)}
+			So(root.Configure(), ShouldNotBeNil)
+		})
+
+		Convey("started servers should be stopped if a server fails to start", func() {
+			os.Args = []string{"http.test", "--config.mem", fmt.Sprintf(`{"public": {"port": -1}, "admin": {"port": %d}}`, adminPort)}
+			So(root.Configure(), ShouldBeNil)
+			So(root.Start(), ShouldNotBeNil)
+			// The admin server starts first and is stopped once public fails
+			So(root.Health().Status, ShouldEqual, component.HealthUnhealthy)
+			_, err := http.Get(fmt.Sprintf("http://localhost:%d/hello", adminPort))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
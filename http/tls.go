@@ -0,0 +1,214 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anuvu/cube/component"
+)
+
+// DefaultReloadInterval is the interval at which the certificate files are
+// checked for changes, if the reload interval is not configured.
+const DefaultReloadInterval = 10 * time.Second
+
+// tlsConfiguration defines the TLS parameters of the http server. Mutual TLS
+// is enabled when the client CA file is configured.
+type tlsConfiguration struct {
+	// PEM encoded certificate chain and private key of the server
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// PEM encoded certificates of the CAs that sign the client certificates
+	ClientCAFile string `json:"client_ca_file"`
+	// Minimum TLS version, one of "1.0", "1.1", "1.2" or "1.3"
+	MinVersion string `json:"min_version"`
+	// Names of the cipher suites as defined by crypto/tls, for example
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". The suites with known security
+	// issues are rejected and TLS 1.3 suites are not configurable.
+	CipherSuites []string `json:"cipher_suites"`
+	// Interval at which the certificate files are checked for changes, as a
+	// duration string such as "1m"
+	ReloadInterval string `json:"reload_interval"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig creates the TLS configuration of the server from the TLS
+// parameters. The server certificate and the client CAs are reloaded when the
+// files change.
+func newTLSConfig(ctx component.Context, c *tlsConfiguration) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("tls requires both cert_file and key_file")
+	}
+
+	interval := DefaultReloadInterval
+	if c.ReloadInterval != "" {
+		d, err := time.ParseDuration(c.ReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reload interval: %v", err)
+		}
+		interval = d
+	}
+	r := &certReloader{ctx: ctx, certFile: c.CertFile, keyFile: c.KeyFile, caFile: c.ClientCAFile, interval: interval, checked: time.Now()}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{GetCertificate: r.GetCertificate, MinVersion: tls.VersionTLS12}
+
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls version %s", c.MinVersion)
+		}
+		cfg.MinVersion = v
+	}
+
+	if len(c.CipherSuites) > 0 {
+		suites := map[string]uint16{}
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		insecure := map[string]bool{}
+		for _, s := range tls.InsecureCipherSuites() {
+			insecure[s.Name] = true
+		}
+		for _, name := range c.CipherSuites {
+			if insecure[name] {
+				return nil, fmt.Errorf("insecure cipher suite %s", name)
+			}
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %s", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	if c.ClientCAFile != "" {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.GetConfigForClient = r.configForClient(cfg.Clone())
+	}
+	return cfg, nil
+}
+
+// certReloader serves the server certificate and the client CAs and reloads
+// them from the files on the handshakes once the files are modified. If the
+// modified files can not be loaded the previous certificates are served. The files are read outside of the
+// lock so that the other handshakes are served the current certificate during
+// the reload.
+type certReloader struct {
+	ctx       component.Context
+	certFile  string
+	keyFile   string
+	caFile    string
+	interval  time.Duration
+	lock      sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time
+	checked   time.Time
+}
+
+// GetCertificate returns the current certificate of the server.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	return cert, nil
+}
+
+// configForClient returns the callback serving the base configuration with the
+// current client CAs on every handshake.
+func (r *certReloader) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		_, pool := r.current()
+		cfg := base.Clone()
+		cfg.ClientCAs = pool
+		return cfg, nil
+	}
+}
+
+// current returns the current certificate and client CAs, reloading them if
+// the files were modified since the last check.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.lock.Lock()
+	cert, pool, modTime := r.cert, r.clientCAs, r.modTime
+	check := time.Since(r.checked) >= r.interval
+	if check {
+		r.checked = time.Now()
+	}
+	r.lock.Unlock()
+
+	if !check {
+		return cert, pool
+	}
+	if mt, err := r.lastModified(); err == nil && mt.After(modTime) {
+		if err := r.load(); err != nil {
+			r.ctx.Log().Error().Error(err).Str("cert", r.certFile).Msg("error reloading certificate")
+		} else {
+			r.ctx.Log().Info().Str("cert", r.certFile).Msg("reloaded certificate")
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.cert, r.clientCAs
+}
+
+// load reads the certificate and the client CAs from the files and swaps them
+// in.
+func (r *certReloader) load() error {
+	mt, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if mt.After(r.modTime) {
+		r.cert = &cert
+		r.clientCAs = pool
+		r.modTime = mt
+	}
+	return nil
+}
+
+// lastModified returns the latest modification time of the certificate, the
+// key and the client CA files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var mt time.Time
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return mt, err
+		}
+		if fi.ModTime().After(mt) {
+			mt = fi.ModTime()
+		}
+	}
+	return mt, nil
+}
@@ -0,0 +1,222 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zlog"
+
+	"github.com/anuvu/cube/component"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA() *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert, key, pool}
+}
+
+// issue writes a certificate signed by the CA and its key to the files.
+func (ca *testCA) issue(serial int64, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	So(err, ShouldBeNil)
+	kder, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+	So(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600), ShouldBeNil)
+}
+
+func (ca *testCA) writeCert(file string) {
+	So(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600), ShouldBeNil)
+}
+
+func tlsGet(cfg *tls.Config) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(fmt.Sprintf("https://localhost:%d/foo", port))
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestTLSServer(t *testing.T) {
+	Convey("With a CA issuing the server certificate", t, func() {
+		dir, err := ioutil.TempDir("", "cube-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		certFile := filepath.Join(dir, "server.crt")
+		keyFile := filepath.Join(dir, "server.key")
+		ca := newTestCA()
+		ca.issue(2, certFile, keyFile)
+
+		ctx := component.RootContext(zlog.New("http.test"))
+		srv := New(ctx).(*server)
+		cfg := srv.Config().(*configuration)
+		cfg.Port = port
+		cfg.TLS = &tlsConfiguration{CertFile: certFile, KeyFile: keyFile, ReloadInterval: "0s"}
+
+		Convey("http server should serve over TLS", func() {
+			So(srv.Configure(ctx), ShouldBeNil)
+			So(srv.Start(ctx), ShouldBeNil)
+			defer srv.Stop(ctx)
+			srv.Register("/foo", testHandler{})
+
+			resp, err := tlsGet(&tls.Config{RootCAs: ca.pool})
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.TLS.PeerCertificates[0].SerialNumber.Int64(), ShouldEqual, 2)
+
+			Convey("and reload the certificate when the files change", func() {
+				ca.issue(3, certFile, keyFile)
+				future := time.Now().Add(time.Minute)
+				So(os.Chtimes(certFile, future, future), ShouldBeNil)
+
+				resp, err := tlsGet(&tls.Config{RootCAs: ca.pool})
+				So(err, ShouldBeNil)
+				So(resp.TLS.PeerCertificates[0].SerialNumber.Int64(), ShouldEqual, 3)
+			})
+
+			Convey("and keep the certificate if the changed files are invalid", func() {
+				So(ioutil.WriteFile(keyFile, []byte("garbage"), 0600), ShouldBeNil)
+				future := time.Now().Add(time.Minute)
+				So(os.Chtimes(keyFile, future, future), ShouldBeNil)
+
+				resp, err := tlsGet(&tls.Config{RootCAs: ca.pool})
+				So(err, ShouldBeNil)
+				So(resp.TLS.PeerCertificates[0].SerialNumber.Int64(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("http server should enforce the minimum TLS version", func() {
+			cfg.TLS.MinVersion = "1.3"
+			So(srv.Configure(ctx), ShouldBeNil)
+			So(srv.Start(ctx), ShouldBeNil)
+			defer srv.Stop(ctx)
+			srv.Register("/foo", testHandler{})
+
+			_, err := tlsGet(&tls.Config{RootCAs: ca.pool, MaxVersion: tls.VersionTLS12})
+			So(err, ShouldNotBeNil)
+			_, err = tlsGet(&tls.Config{RootCAs: ca.pool})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("http server should require client certificates for mutual TLS", func() {
+			caFile := filepath.Join(dir, "ca.crt")
+			ca.writeCert(caFile)
+			cfg.TLS.ClientCAFile = caFile
+			cfg.TLS.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+			So(srv.Configure(ctx), ShouldBeNil)
+			So(srv.Start(ctx), ShouldBeNil)
+			defer srv.Stop(ctx)
+			srv.Register("/foo", testHandler{})
+
+			_, err := tlsGet(&tls.Config{RootCAs: ca.pool})
+			So(err, ShouldNotBeNil)
+
+			clientCert := filepath.Join(dir, "client.crt")
+			clientKey := filepath.Join(dir, "client.key")
+			ca.issue(4, clientCert, clientKey)
+			cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+			So(err, ShouldBeNil)
+			resp, err := tlsGet(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12})
+			So(err, ShouldBeNil)
+			So(resp.TLS.CipherSuite, ShouldEqual, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+
+			Convey("and reload the client CAs when the file changes", func() {
+				clientCA := newTestCA()
+				clientCA.issue(5, clientCert, clientKey)
+				rotated, err := tls.LoadX509KeyPair(clientCert, clientKey)
+				So(err, ShouldBeNil)
+				_, err = tlsGet(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{rotated}, MaxVersion: tls.VersionTLS12})
+				So(err, ShouldNotBeNil)
+
+				clientCA.writeCert(caFile)
+				future := time.Now().Add(time.Minute)
+				So(os.Chtimes(caFile, future, future), ShouldBeNil)
+				_, err = tlsGet(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{rotated}, MaxVersion: tls.VersionTLS12})
+				So(err, ShouldBeNil)
+				_, err = tlsGet(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("http server should reject invalid TLS parameters", func() {
+			cfg.TLS.MinVersion = "2.0"
+			So(srv.Configure(ctx), ShouldNotBeNil)
+			cfg.TLS.MinVersion = ""
+			cfg.TLS.CipherSuites = []string{"TLS_NO_SUCH_SUITE"}
+			So(srv.Configure(ctx), ShouldNotBeNil)
+			cfg.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+			So(srv.Configure(ctx), ShouldBeError, "insecure cipher suite TLS_RSA_WITH_RC4_128_SHA")
+			cfg.TLS.CipherSuites = nil
+			cfg.TLS.KeyFile = ""
+			So(srv.Configure(ctx), ShouldNotBeNil)
+			cfg.TLS.KeyFile = filepath.Join(dir, "missing.key")
+			So(srv.Configure(ctx), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestBindHost(t *testing.T) {
+	Convey("http server should listen on the configured host", t, func() {
+		ctx := component.RootContext(zlog.New("http.test"))
+		srv := New(ctx).(*server)
+		cfg := srv.Config().(*configuration)
+		cfg.Host = "127.0.0.1"
+		cfg.Port = port
+		So(srv.Configure(ctx), ShouldBeNil)
+		So(srv.Start(ctx), ShouldBeNil)
+		defer srv.Stop(ctx)
+		srv.Register("/foo", testHandler{})
+		So(srv.server.Addr, ShouldEqual, fmt.Sprintf("127.0.0.1:%d", port))
+
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/foo", port))
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+	})
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"github.com/anuvu/cube/component"
+)
+
+// phaseBuckets are the upper bounds in seconds of the histogram buckets of the
+// lifecycle phase durations.
+var phaseBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 120}
+
+// New creates the metrics registry of the process for adding to a component
+// group. Components record their metrics by injecting the Registry into their
+// constructors.
+//
+// The registry records the durations of the lifecycle phases of the group
+// hierarchy. See the httpmetrics package for exposing the registry on the http
+// server and recording the requests served by the server.
+func New(ctx component.Context, root component.Group) Registry {
+	r := NewRegistry()
+	root.Subscribe(LifecycleObserver(r))
+	return r
+}
+
+// LifecycleObserver records the duration of each lifecycle phase of the groups
+// and the failures of the phases.
+func LifecycleObserver(r Registry) func(component.Transition) {
+	durations := r.Histogram("lifecycle_phase_duration_seconds", "Durations of the lifecycle phases of the groups.", phaseBuckets, "group", "phase")
+	failures := r.Counter("lifecycle_phase_failures_total", "Number of failed lifecycle phases of the groups.", "group", "phase")
+	return func(t component.Transition) {
+		if t.To == component.StateStopping {
+			// Stop phase is still in progress
+			return
+		}
+		durations.Observe(t.Duration.Seconds(), t.Group, string(t.Phase))
+		if t.To == component.StateFailed {
+			failures.Inc(t.Group, string(t.Phase))
+		}
+	}
+}
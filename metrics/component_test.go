@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/anuvu/cube/component"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type jobs struct {
+	done *Counter
+}
+
+func newJobs(r Registry) *jobs {
+	return &jobs{r.Counter("jobs_done_total", "Number of jobs done.")}
+}
+
+func TestMetricsComponent(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"metrics.test"}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we start a group with metrics", t, func() {
+		root := component.New("root")
+		So(root.Add(New), ShouldBeNil)
+		child := root.New("child")
+		So(child.Add(newJobs), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+		So(root.Start(), ShouldBeNil)
+		defer root.Stop()
+
+		child.Invoke(func(j *jobs) { j.done.Inc() })
+
+		Convey("the registry should record the metrics of the components and the lifecycle", func() {
+			var r Registry
+			So(root.Invoke(func(reg Registry) { r = reg }), ShouldBeNil)
+			buf := &bytes.Buffer{}
+			So(r.WriteText(buf), ShouldBeNil)
+			text := buf.String()
+			So(text, ShouldContainSubstring, "jobs_done_total 1\n")
+			So(text, ShouldContainSubstring, "# TYPE lifecycle_phase_duration_seconds histogram\n")
+			So(text, ShouldContainSubstring, `lifecycle_phase_duration_seconds_count{group="root",phase="start"} 1`)
+			So(text, ShouldContainSubstring, `lifecycle_phase_duration_seconds_bucket{group="root/child",phase="configure",le="+Inf"} 1`)
+		})
+	})
+}
@@ -0,0 +1,74 @@
+// Package httpmetrics exposes a metrics registry on the http server and records
+// the requests served by the server.
+package httpmetrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anuvu/cube/component"
+	cubehttp "github.com/anuvu/cube/http"
+	"github.com/anuvu/cube/metrics"
+)
+
+// Path is the path of the text exposition endpoint.
+const Path = "/metrics"
+
+// Exporter exposes the metrics registry on the http server.
+type Exporter struct{}
+
+// New creates the exporter of the metrics registry for adding to a component
+// group along with the registry and the http server.
+//
+// The registry is exposed on the http server at /metrics, and records the counts
+// and latencies of the requests served by the http server.
+func New(ctx component.Context, r metrics.Registry, s cubehttp.Server) *Exporter {
+	s.Use(cubehttp.OrderLogging, "metrics", Middleware(r))
+	s.Handle(http.MethodGet, Path, Handler(ctx, r))
+	return &Exporter{}
+}
+
+// Handler serves the metrics of the registry in the text exposition format.
+func Handler(ctx component.Context, r metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", metrics.TextContentType)
+		if err := r.WriteText(w); err != nil {
+			ctx.Log().Info().Error(err).Msg("error writing metrics")
+		}
+	})
+}
+
+// methods are the http methods the requests are labelled with, the requests
+// with any other method are labelled "other" so that the clients can't create
+// an unbounded number of series.
+var methods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Middleware records the counts and the latencies of the http requests.
+func Middleware(r metrics.Registry) cubehttp.Middleware {
+	requests := r.Counter("http_requests_total", "Number of the http requests served.", "method", "code")
+	latencies := r.Histogram("http_request_duration_seconds", "Latencies of the http requests.", nil, "method")
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			sw := cubehttp.NewStatusWriter(w)
+			h.ServeHTTP(sw, req)
+			method := req.Method
+			if !methods[method] {
+				method = "other"
+			}
+			requests.Inc(method, strconv.Itoa(sw.Status()))
+			latencies.Observe(time.Since(start).Seconds(), method)
+		})
+	}
+}
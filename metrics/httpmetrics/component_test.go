@@ -0,0 +1,68 @@
+package httpmetrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/anuvu/cube/component"
+	cubehttp "github.com/anuvu/cube/http"
+	"github.com/anuvu/cube/metrics"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const port = 8993
+
+func scrape(path string) (*http.Response, string) {
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+	So(err, ShouldBeNil)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	So(err, ShouldBeNil)
+	return resp, string(b)
+}
+
+func TestExporter(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"httpmetrics.test", "--config.mem", fmt.Sprintf(`{"http": {"port": %d}}`, port)}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we start a group exporting the metrics", t, func() {
+		root := component.New("root")
+		So(root.Add(cubehttp.New), ShouldBeNil)
+		So(root.Add(metrics.New), ShouldBeNil)
+		So(root.Add(New), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+		So(root.Start(), ShouldBeNil)
+		defer root.Stop()
+
+		Convey("the metrics should be scraped from the endpoint", func() {
+			scrape("/missing")
+			resp, text := scrape(Path)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, metrics.TextContentType)
+			So(text, ShouldContainSubstring, `lifecycle_phase_duration_seconds_count{group="root",phase="start"} 1`)
+			So(text, ShouldContainSubstring, `http_requests_total{method="GET",code="404"} 1`)
+			So(text, ShouldContainSubstring, `http_request_duration_seconds_count{method="GET"} 1`)
+		})
+
+		Convey("the unknown methods should share a label", func() {
+			// the connections of the servers stopped by the previous runs are
+			// not retried for the unknown methods
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			for _, m := range []string{"FOO", "BAR"} {
+				req, err := http.NewRequest(m, fmt.Sprintf("http://localhost:%d/missing", port), nil)
+				So(err, ShouldBeNil)
+				resp, err := client.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+			}
+			_, text := scrape(Path)
+			So(text, ShouldContainSubstring, `http_request_duration_seconds_count{method="other"} 2`)
+			So(text, ShouldNotContainSubstring, `method="FOO"`)
+		})
+	})
+}
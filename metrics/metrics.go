@@ -0,0 +1,203 @@
+// Package metrics provides counters, gauges and histograms with labels that are
+// exposed in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the histogram buckets suited for the
+// latencies of the network services in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry registers the metrics and writes them in the text exposition format.
+//
+// Registering a metric with the name of an existing metric returns the existing
+// metric, so that the components can share the metrics. It panics if the type
+// or the labels of the metrics differ, as it is a programming error.
+type Registry interface {
+	Counter(name, help string, labels ...string) *Counter
+	Gauge(name, help string, labels ...string) *Gauge
+	Histogram(name, help string, buckets []float64, labels ...string) *Histogram
+	WriteText(w io.Writer) error
+}
+
+var nameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+type registry struct {
+	lock    sync.Mutex
+	metrics map[string]*metric
+}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() Registry {
+	return &registry{metrics: map[string]*metric{}}
+}
+
+func (r *registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, counterType, nil, labels)}
+}
+
+func (r *registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, gaugeType, nil, labels)}
+}
+
+func (r *registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, histogramType, buckets, labels)}
+}
+
+func (r *registry) register(name, help string, typ metricType, buckets []float64, labels []string) *metric {
+	if !nameRE.MatchString(name) {
+		panic(fmt.Sprintf("invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !nameRE.MatchString(l) || strings.HasPrefix(l, "__") || l == "le" {
+			panic(fmt.Sprintf("invalid label name %q of metric %s", l, name))
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if m.typ != typ || strings.Join(m.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metric %s is already registered as %s with labels %v", name, m.typ, m.labels))
+		}
+		return m
+	}
+	m := &metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  append([]string{}, labels...),
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// metric is a family of series of the metric, one per label values.
+type metric struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// with calls the function with the series of the label values, creating the
+// series on first use. It panics if the number of values does not match the
+// labels of the metric.
+func (m *metric) with(values []string, f func(s *series)) {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	f(s)
+}
+
+// Counter is a metric whose value only goes up, such as the number of requests.
+type Counter struct {
+	m *metric
+}
+
+// Inc increments the counter of the label values by 1.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds the non-negative delta to the counter of the label values.
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.m.name))
+	}
+	c.m.with(values, func(s *series) { s.value += delta })
+}
+
+// Gauge is a metric whose value goes up and down, such as the number of
+// connections.
+type Gauge struct {
+	m *metric
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(value float64, values ...string) {
+	g.m.with(values, func(s *series) { s.value = value })
+}
+
+// Add adds the delta, which can be negative, to the gauge of the label values.
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.m.with(values, func(s *series) { s.value += delta })
+}
+
+// Histogram is a metric that counts the observations, such as the request
+// latencies, in configurable buckets.
+type Histogram struct {
+	m *metric
+}
+
+// Observe adds the observation to the histogram of the label values.
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.m.with(values, func(s *series) {
+		for i, b := range h.m.buckets {
+			if value <= b {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.sum += value
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+	Convey("With a metrics registry", t, func() {
+		r := NewRegistry()
+		text := func() string {
+			b := &bytes.Buffer{}
+			So(r.WriteText(b), ShouldBeNil)
+			return b.String()
+		}
+
+		Convey("counters and gauges should be exposed with their labels", func() {
+			c := r.Counter("jobs_total", "Number of jobs.", "queue", "result")
+			c.Inc("emails", "ok")
+			c.Add(2, "emails", "ok")
+			c.Inc("sms", "failed")
+			g := r.Gauge("queue_depth", "Depth of the queue.\nIn jobs.")
+			g.Set(10)
+			g.Add(-3)
+
+			So(text(), ShouldEqual, `# HELP jobs_total Number of jobs.
+# TYPE jobs_total counter
+jobs_total{queue="emails",result="ok"} 3
+jobs_total{queue="sms",result="failed"} 1
+# HELP queue_depth Depth of the queue.\nIn jobs.
+# TYPE queue_depth gauge
+queue_depth 7
+`)
+		})
+
+		Convey("histograms should expose cumulative buckets", func() {
+			h := r.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "op")
+			h.Observe(0.05, "read")
+			h.Observe(0.5, "read")
+			h.Observe(5, "read")
+
+			So(text(), ShouldEqual, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="read",le="0.1"} 1
+latency_seconds_bucket{op="read",le="1"} 2
+latency_seconds_bucket{op="read",le="+Inf"} 3
+latency_seconds_sum{op="read"} 5.55
+latency_seconds_count{op="read"} 3
+`)
+		})
+
+		Convey("label values should be escaped", func() {
+			r.Counter("errors_total", "Errors.", "msg").Inc("bad \"quote\"\n")
+			So(text(), ShouldContainSubstring, `errors_total{msg="bad \"quote\"\n"} 1`)
+		})
+
+		Convey("registering an existing metric should return it", func() {
+			r.Counter("jobs_total", "Number of jobs.", "queue").Inc("emails")
+			r.Counter("jobs_total", "Number of jobs.", "queue").Inc("emails")
+			So(text(), ShouldContainSubstring, `jobs_total{queue="emails"} 2`)
+		})
+
+		Convey("programming errors should panic", func() {
+			c := r.Counter("jobs_total", "Number of jobs.", "queue")
+			So(func() { r.Gauge("jobs_total", "Number of jobs.", "queue") }, ShouldPanic)
+			So(func() { r.Counter("jobs_total", "Number of jobs.") }, ShouldPanic)
+			So(func() { r.Counter("jobs-total", "Number of jobs.") }, ShouldPanic)
+			So(func() { r.Counter("runs_total", "Runs.", "le") }, ShouldPanic)
+			So(func() { c.Inc() }, ShouldPanic)
+			So(func() { c.Add(-1, "emails") }, ShouldPanic)
+		})
+	})
+}
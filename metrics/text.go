@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+)
+
+// TextContentType is the content type of the text exposition format.
+const TextContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// WriteText writes all the metrics in the text exposition format, sorted by
+// their names and label values.
+func (r *registry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]*metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.lock.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+func (m *metric) writeText(w *bufio.Writer) {
+	w.WriteString("# HELP " + m.name + " " + helpEscaper.Replace(m.help) + "\n")
+	w.WriteString("# TYPE " + m.name + " " + string(m.typ) + "\n")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.typ != histogramType {
+			writeSample(w, m.name, m.labels, s.values, "", "", s.value)
+			continue
+		}
+		for i, b := range m.buckets {
+			writeSample(w, m.name+"_bucket", m.labels, s.values, "le", formatFloat(b), float64(s.counts[i]))
+		}
+		writeSample(w, m.name+"_bucket", m.labels, s.values, "le", "+Inf", float64(s.count))
+		writeSample(w, m.name+"_sum", m.labels, s.values, "", "", s.sum)
+		writeSample(w, m.name+"_count", m.labels, s.values, "", "", float64(s.count))
+	}
+}
+
+// writeSample writes a sample line, with the extra label if it is not empty.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extra, extraValue string, v float64) {
+	pairs := make([]string, 0, len(labels)+1)
+	for i, l := range labels {
+		pairs = append(pairs, l+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra+`="`+extraValue+`"`)
+	}
+	w.WriteString(name)
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
@@ -0,0 +1,297 @@
+package msgbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/anuvu/cube/tracing"
+	"github.com/satori/go.uuid"
+)
+
+var (
+	// ErrClosed the msgbus stopped before the response was received
+	ErrClosed = errors.New("msgbus: closed")
+)
+
+// InboxPrefix prefixes the topics on which a msgbus receives the responses to
+// its asynchronous requests.
+const InboxPrefix = "_INBOX."
+
+// Future is the pending response of an asynchronous request.
+type Future struct {
+	handle    uuid.UUID
+	target    string
+	start     time.Time
+	done      chan struct{}
+	once      sync.Once
+	lock      sync.Mutex
+	callbacks []func([]byte, uuid.UUID, error)
+	resp      []byte
+	err       error
+	span      *tracing.Span
+	timer     *time.Timer
+}
+
+// Handle returns the handle of the request.
+func (f *Future) Handle() uuid.UUID {
+	return f.handle
+}
+
+// Done returns a channel that is closed once the response is received or the
+// request failed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the response is received or the request failed.
+func (f *Future) Wait() ([]byte, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// then calls the function on its own goroutine once the future completes,
+// right away if it is already complete.
+func (f *Future) then(cb func([]byte, uuid.UUID, error)) {
+	f.lock.Lock()
+	select {
+	case <-f.done:
+		f.lock.Unlock()
+		go cb(f.resp, f.handle, f.err)
+		return
+	default:
+	}
+	f.callbacks = append(f.callbacks, cb)
+	f.lock.Unlock()
+}
+
+// complete records the outcome of the request and notifies the callbacks. Only
+// the first completion takes effect, it returns false for the later ones. The
+// callbacks run on their own goroutines as the completion may run on the
+// goroutine dispatching the responses, which a callback stopping the msgbus
+// waits for.
+func (f *Future) complete(resp []byte, err error) bool {
+	completed := false
+	f.once.Do(func() {
+		completed = true
+		f.span.SetError(err)
+		f.span.End()
+
+		f.lock.Lock()
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		f.resp, f.err = resp, err
+		close(f.done)
+		callbacks := f.callbacks
+		f.callbacks = nil
+		f.lock.Unlock()
+		for _, cb := range callbacks {
+			go cb(resp, f.handle, err)
+		}
+	})
+	return completed
+}
+
+// inbox tracks the pending asynchronous requests of a msgbus. All the responses
+// are received on a single subscription and dispatched by their handles.
+type inbox struct {
+	lock    sync.Mutex
+	topic   string
+	sub     Subscription
+	pending map[uuid.UUID]*Future
+}
+
+// SendAsync sends a request to the target and returns the future of its
+// response. The request fails with ErrTimeout if the response is not received
+// within the timeout, if positive, and with the error of the context if the
+// context is done before.
+func (mb *msgbus) SendAsync(ctx context.Context, data []byte, target string, timeout time.Duration) (*Future, error) {
+	if mb == nil {
+		return nil, ErrNotInited
+	}
+	if mb.broker == nil {
+		return nil, ErrNotInited
+	}
+	if target == "" {
+		return nil, ErrBadSub
+	}
+	if len(data) == 0 {
+		return nil, ErrBadPayload
+	}
+	topic, err := mb.inboxTopic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := mb.startSpan(ctx, "msgbus request "+target)
+	msg := newMsg(data)
+	msg.Flags |= MsgFlagsRespExpected
+	msg.TimeoutMs = int64((timeout + time.Millisecond - 1) / time.Millisecond)
+	msg.ReplyTo = topic
+	inject(ctx, msg)
+	msg.GenerateHash()
+	u, err := uuid.FromBytes(msg.GetHandle())
+	if err != nil {
+		span.End()
+		return nil, &MsgError{Target: target, Err: ErrBadHandle}
+	}
+	f := &Future{handle: u, target: target, start: time.Now(), done: make(chan struct{}), span: span}
+	d, err := Marshal(msg)
+	if err != nil {
+		mb.stats.count(target, EventFailed)
+		err = newMsgError(target, msg, err)
+		f.complete(nil, err)
+		return nil, err
+	}
+
+	// The future is pending before the request is sent as the response can
+	// arrive before Send returns
+	mb.inbox.lock.Lock()
+	if mb.inbox.pending == nil {
+		mb.inbox.lock.Unlock()
+		f.complete(nil, ErrClosed)
+		return nil, ErrClosed
+	}
+	mb.inbox.pending[u] = f
+	mb.inbox.lock.Unlock()
+	if timeout > 0 {
+		f.lock.Lock()
+		f.timer = time.AfterFunc(timeout, func() {
+			if mb.fail(f, ErrTimeout) {
+				mb.stats.count(target, EventTimedOut)
+			}
+		})
+		f.lock.Unlock()
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				mb.fail(f, ctx.Err())
+			case <-f.done:
+			}
+		}()
+	}
+
+	if err := mb.broker.Send(d, target); err != nil {
+		if mb.fail(f, err) {
+			mb.stats.count(target, EventFailed)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// SendWithCallback sends a request to the target and calls the callback with
+// the response or the error once the request completes. The callback runs on
+// its own goroutine, it may block or stop the msgbus.
+func (mb *msgbus) SendWithCallback(ctx context.Context, data []byte, target string, timeout time.Duration, cb func([]byte, uuid.UUID, error)) error {
+	f, err := mb.SendAsync(ctx, data, target, timeout)
+	if err != nil {
+		return err
+	}
+	f.then(cb)
+	return nil
+}
+
+// inboxTopic returns the inbox topic of the msgbus, subscribing to it on the
+// first asynchronous request.
+func (mb *msgbus) inboxTopic() (string, error) {
+	mb.inbox.lock.Lock()
+	defer mb.inbox.lock.Unlock()
+	if mb.inbox.sub != nil {
+		return mb.inbox.topic, nil
+	}
+	topic := InboxPrefix + uuid.NewV4().String()
+	sub, err := mb.broker.Subscribe(topic, "", mb.dispatch)
+	if err != nil {
+		return "", err
+	}
+	mb.inbox.topic, mb.inbox.sub = topic, sub
+	mb.inbox.pending = map[uuid.UUID]*Future{}
+	return topic, nil
+}
+
+// dispatch completes the pending request the response is addressed to.
+func (mb *msgbus) dispatch(data []byte) ([]byte, error) {
+	mb.inbox.lock.Lock()
+	topic := mb.inbox.topic
+	mb.inbox.lock.Unlock()
+
+	m, err := Unmarshal(data)
+	if err != nil {
+		return nil, mb.drop(topic, nil, err)
+	}
+	u, err := uuid.FromBytes(m.GetHandle())
+	if err != nil {
+		return nil, mb.drop(topic, m, ErrBadHandle)
+	}
+	mb.inbox.lock.Lock()
+	f, ok := mb.inbox.pending[u]
+	delete(mb.inbox.pending, u)
+	mb.inbox.lock.Unlock()
+	if !ok {
+		// the request already completed, e.g. it timed out
+		return nil, mb.drop(topic, m, ErrRespMismatch)
+	}
+
+	if err := m.VerifyHash(); err != nil {
+		mb.stats.count(f.target, EventHashMismatch)
+		mb.stats.count(f.target, EventDropped)
+		f.complete(nil, newMsgError(f.target, m, err))
+		return nil, err
+	}
+	if m.GetFlags()&MsgFlagsError == MsgFlagsError {
+		mb.stats.count(f.target, EventFailed)
+		f.complete(nil, newMsgError(f.target, m, errors.New(string(m.GetPayload()))))
+		return nil, nil
+	}
+	mb.stats.count(f.target, EventSent)
+	mb.stats.observeRoundTrip(f.target, time.Since(f.start))
+	f.complete(m.GetPayload(), nil)
+	return nil, nil
+}
+
+// fail removes the pending request and completes it with the error. It returns
+// false if the request already completed.
+func (mb *msgbus) fail(f *Future, err error) bool {
+	mb.inbox.lock.Lock()
+	delete(mb.inbox.pending, f.handle)
+	mb.inbox.lock.Unlock()
+	return f.complete(nil, err)
+}
+
+// closeInbox unsubscribes from the inbox and fails the pending requests.
+func (mb *msgbus) closeInbox() {
+	mb.inbox.lock.Lock()
+	sub, pending := mb.inbox.sub, mb.inbox.pending
+	mb.inbox.sub, mb.inbox.pending = nil, nil
+	mb.inbox.lock.Unlock()
+	if sub != nil {
+		sub.Unsubscribe()
+	}
+	for _, f := range pending {
+		f.complete(nil, ErrClosed)
+	}
+}
+
+// reply publishes the response, or the error, of the handler on the inbox of
+// the sender.
+func (mb *msgbus) reply(target string, msg *Msg, d []byte, err error) {
+	if err != nil {
+		r, rerr := msg.MakeReply([]byte(err.Error()))
+		if rerr != nil {
+			return
+		}
+		r.Flags |= MsgFlagsError
+		r.GenerateHash()
+		if d, err = Marshal(r); err != nil {
+			return
+		}
+	}
+	if err := mb.broker.Publish(d, msg.GetReplyTo()); err != nil && mb.ctx != nil {
+		mb.ctx.Log().Error().Error(newMsgError(target, msg, err)).Str("target", target).Msg("failed to reply")
+	}
+}
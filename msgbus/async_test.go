@@ -0,0 +1,106 @@
+package msgbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendAsync(t *testing.T) {
+	mb := newMsgbus()
+	mb.config.MsgbusType = "mock"
+	assert.Nil(t, mb.Start(nil))
+
+	assert.Nil(t, mb.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+	assert.Nil(t, mb.RegisterHandler("fail", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return nil, errors.New("no luck")
+	}))
+	// the requests to the blackhole are never answered
+	assert.Nil(t, mb.broker.(MsgBroker).RegisterBrokerHandler("blackhole", func(data []byte) ([]byte, error) {
+		return nil, nil
+	}))
+
+	_, err := mb.SendAsync(context.Background(), nil, "echo", 0)
+	assert.Equal(t, ErrBadPayload, err)
+
+	// many requests are in flight over a single inbox
+	futures := []*Future{}
+	for i := 0; i < 10; i++ {
+		f, err := mb.SendAsync(context.Background(), []byte(fmt.Sprintf("msg%d", i)), "echo", time.Second)
+		assert.Nil(t, err)
+		futures = append(futures, f)
+	}
+	for i, f := range futures {
+		<-f.Done()
+		r, err := f.Wait()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("msg%d", i), string(r))
+	}
+	assert.Equal(t, uint64(10), mb.Stats()["echo"].Events[EventSent])
+	assert.Equal(t, uint64(10), mb.Stats()["echo"].RoundTrip.Count)
+
+	// the errors of the handlers are returned
+	f, err := mb.SendAsync(context.Background(), []byte("hello"), "fail", time.Second)
+	assert.Nil(t, err)
+	_, err = f.Wait()
+	assert.EqualError(t, err, fmt.Sprintf("fail [%s]: no luck", f.Handle()))
+
+	// callbacks are called once the request completes
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, mb.SendWithCallback(context.Background(), []byte("hello"), "echo", time.Second, func(r []byte, u uuid.UUID, err error) {
+		defer wg.Done()
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(r))
+	}))
+	wg.Wait()
+
+	// unanswered requests time out
+	f, err = mb.SendAsync(context.Background(), []byte("hello"), "blackhole", 10*time.Millisecond)
+	assert.Nil(t, err)
+	_, err = f.Wait()
+	assert.Equal(t, ErrTimeout, err)
+	assert.Equal(t, uint64(1), mb.Stats()["blackhole"].Events[EventTimedOut])
+
+	// cancelling the context fails the request
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err = mb.SendAsync(ctx, []byte("hello"), "blackhole", 0)
+	assert.Nil(t, err)
+	cancel()
+	_, err = f.Wait()
+	assert.Equal(t, context.Canceled, err)
+
+	// stopping the msgbus fails the pending requests
+	f, err = mb.SendAsync(context.Background(), []byte("hello"), "blackhole", 0)
+	assert.Nil(t, err)
+	assert.Nil(t, mb.Stop(nil))
+	_, err = f.Wait()
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestCallbackStopsMsgbus(t *testing.T) {
+	mb := newInprocMsgbus(t, "inproc://callback")
+	assert.Nil(t, mb.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+
+	// the callbacks do not run on the goroutine dispatching the responses
+	stopped := make(chan error, 1)
+	assert.Nil(t, mb.SendWithCallback(context.Background(), []byte("hello"), "echo", time.Second, func(r []byte, u uuid.UUID, err error) {
+		stopped <- mb.Stop(nil)
+	}))
+	select {
+	case err := <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the callback stopping the msgbus deadlocked")
+	}
+}
@@ -12,7 +12,8 @@ type Broker interface {
 	Register() error
 	// Unregister the bus
 	Unregister() error
-	// RegisterMsgHandler registers a listening msg handler
+	// RegisterMsgHandler registers a listening msg handler, called with the
+	// payload of the msgs and whether a response is expected
 	RegisterMsgHandler(string, func([]byte, bool) ([]byte, error)) error
 	// UnregisterMsgHandler unregister listening a msg handler
 	UnregisterMsgHandler(string) error
@@ -21,6 +22,53 @@ type Broker interface {
 	// SendAndWaitResponse sends a byte payload to a named receiver and wait for
 	// response within a timeout
 	SendAndWaitResponse([]byte, string, string, time.Duration) ([]byte, error)
+	// Subscribe a msg handler to the topics matching a pattern, as a member
+	// of a queue group if the queue is not empty
+	Subscribe(string, string, BrokerHandler) (Subscription, error)
+	// Publish a byte payload to all the subscribers of a topic
+	Publish([]byte, string) error
+}
+
+// BrokerHandler handles a marshaled msg delivered by the broker. If the msg
+// expects a response, the handler returns the marshaled reply msg, else nil.
+type BrokerHandler func(data []byte) ([]byte, error)
+
+// MsgBroker is a broker that delivers the marshaled msgs of the targets. The
+// msgbus then decodes the msgs and encodes the replies itself, so that its
+// handlers see the trace, the deadline and the delivery attempt of the msgs.
+// The handlers registered on the other brokers only see the payloads.
+type MsgBroker interface {
+	Broker
+	// RegisterBrokerHandler registers a listening handler of the marshaled
+	// msgs of a target
+	RegisterBrokerHandler(string, BrokerHandler) error
+}
+
+// PayloadHandler adapts a handler of the msg payloads to a BrokerHandler. It
+// lets a MsgBroker implement RegisterMsgHandler.
+func PayloadHandler(h func([]byte, bool) ([]byte, error)) BrokerHandler {
+	return func(data []byte) ([]byte, error) {
+		msg, err := Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := msg.VerifyHash(); err != nil {
+			return nil, err
+		}
+		respExpected := msg.GetFlags()&MsgFlagsRespExpected == MsgFlagsRespExpected
+		r, err := h(msg.GetPayload(), respExpected)
+		if err != nil || !respExpected {
+			return nil, err
+		}
+		if len(r) == 0 {
+			return nil, ErrBadAppResp
+		}
+		reply, err := msg.MakeReply(r)
+		if err != nil {
+			return nil, err
+		}
+		return Marshal(reply)
+	}
 }
 
 var (
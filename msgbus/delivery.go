@@ -0,0 +1,361 @@
+package msgbus
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+var (
+	// ErrNacked the handler nacked the msg without an error
+	ErrNacked = errors.New("msgbus: msg nacked")
+)
+
+const (
+	// DefaultMaxAttempts is the default number of delivery attempts of a msg
+	// before it is dead lettered
+	DefaultMaxAttempts = 10
+	// DefaultRedeliveryBackoff is the default delay before the first
+	// redelivery of a msg, the delay doubles with every failed attempt
+	DefaultRedeliveryBackoff = 100 * time.Millisecond
+	// DefaultMaxRedeliveryBackoff is the default maximum delay between the
+	// delivery attempts of a msg
+	DefaultMaxRedeliveryBackoff = 30 * time.Second
+	// DefaultAckTimeout is the default time a delivery attempt waits for the
+	// ack of the handler
+	DefaultAckTimeout = 5 * time.Second
+)
+
+// DeliveryConfiguration defines the configurable parameters of the at least
+// once delivery of the msgs sent with Send.
+type DeliveryConfiguration struct {
+	// Store is the directory of the file store of the msgs pending delivery,
+	// the msgs are kept in memory if empty
+	Store string `json:"store"`
+	// MaxAttempts is the number of delivery attempts of a msg before it is
+	// dead lettered, 0 for the default and negative for no limit
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff is the delay before the first redelivery of a msg
+	Backoff string `json:"backoff"`
+	// MaxBackoff is the maximum delay between the delivery attempts of a msg
+	MaxBackoff string `json:"max_backoff"`
+	// AckTimeout is the time a delivery attempt waits for the ack
+	AckTimeout string `json:"ack_timeout"`
+	// DeadLetter is the target receiving the msgs that exhausted their
+	// attempts, they are dropped if empty
+	DeadLetter string `json:"dead_letter"`
+}
+
+// WithStore stores the msgs pending delivery in the store instead of the store
+// of the delivery configuration.
+func WithStore(s MsgStore) Option {
+	return func(mb *msgbus) {
+		mb.store = s
+	}
+}
+
+// Delivery is a msg delivered at least once to a DeliveryHandler. The handler
+// acks the msg once handled or nacks it to have it redelivered, possibly after
+// returning but before the context is done. A msg neither acked nor nacked is
+// redelivered.
+type Delivery interface {
+	MsgContext
+	// Attempt returns the delivery attempt of the msg, starting at 1
+	Attempt() int
+	Ack()
+	Nack(err error)
+}
+
+// DeliveryHandler handles the msgs delivered at least once to a target.
+type DeliveryHandler func(d Delivery, data []byte)
+
+type msgDelivery struct {
+	*msgContext
+	once   sync.Once
+	result chan error
+}
+
+func (d *msgDelivery) Attempt() int {
+	return d.attempt
+}
+
+func (d *msgDelivery) Ack() {
+	d.once.Do(func() { d.result <- nil })
+}
+
+func (d *msgDelivery) Nack(err error) {
+	if err == nil {
+		err = ErrNacked
+	}
+	d.once.Do(func() { d.result <- err })
+}
+
+// deliveryHandler adapts a delivery handler to a Handler waiting for the ack.
+// The msgs sent without the at least once delivery are acked once handled.
+func deliveryHandler(h DeliveryHandler) Handler {
+	return func(ctx MsgContext, data []byte) ([]byte, error) {
+		mc := ctx.(*msgContext)
+		d := &msgDelivery{msgContext: mc, result: make(chan error, 1)}
+		h(d, data)
+		if !mc.durable {
+			return nil, nil
+		}
+		select {
+		case err := <-d.result:
+			return nil, err
+		case <-mc.ctx.Done():
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// ack returns the ack of the durable msg, or the error of the handler which
+// nacks the msg.
+func (mb *msgbus) ack(target string, msg *Msg, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return mb.marshalReply(target, msg, nil)
+}
+
+// deliverer delivers the durable msgs until they are acked, backing off
+// between the attempts, and dead letters the msgs that exhausted their
+// attempts.
+type deliverer struct {
+	mb          *msgbus
+	store       MsgStore
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	ackTimeout  time.Duration
+	deadLetter  string
+	lock        sync.Mutex
+	stopped     bool
+	timers      map[uuid.UUID]*time.Timer
+	wg          sync.WaitGroup
+}
+
+func newDeliverer(mb *msgbus, config *DeliveryConfiguration, store MsgStore) (*deliverer, error) {
+	d := &deliverer{
+		mb:          mb,
+		store:       store,
+		maxAttempts: config.MaxAttempts,
+		backoff:     DefaultRedeliveryBackoff,
+		maxBackoff:  DefaultMaxRedeliveryBackoff,
+		ackTimeout:  DefaultAckTimeout,
+		deadLetter:  config.DeadLetter,
+		timers:      map[uuid.UUID]*time.Timer{},
+	}
+	if d.maxAttempts == 0 {
+		d.maxAttempts = DefaultMaxAttempts
+	}
+	for _, p := range []struct {
+		v string
+		d *time.Duration
+	}{
+		{config.Backoff, &d.backoff},
+		{config.MaxBackoff, &d.maxBackoff},
+		{config.AckTimeout, &d.ackTimeout},
+	} {
+		if p.v == "" {
+			continue
+		}
+		v, err := time.ParseDuration(p.v)
+		if err != nil || v <= 0 {
+			return nil, ErrBadConfig
+		}
+		*p.d = v
+	}
+	if d.backoff > d.maxBackoff {
+		return nil, ErrBadConfig
+	}
+
+	if d.store == nil {
+		if config.Store == "" {
+			d.store = NewMemStore()
+		} else {
+			s, err := NewFileStore(config.Store)
+			if err != nil {
+				return nil, err
+			}
+			d.store = s
+		}
+	}
+	return d, nil
+}
+
+// start resumes the delivery of the stored msgs.
+func (d *deliverer) start() error {
+	msgs, err := d.store.Load()
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		d.schedule(m)
+	}
+	return nil
+}
+
+// stop cancels the scheduled attempts and waits for the attempts in flight.
+// The msgs not acked stay in the store.
+func (d *deliverer) stop() {
+	d.lock.Lock()
+	d.stopped = true
+	for h, t := range d.timers {
+		delete(d.timers, h)
+		if t.Stop() {
+			d.wg.Done()
+		}
+	}
+	d.lock.Unlock()
+	d.wg.Wait()
+}
+
+// prepare flags the msg for the at least once delivery.
+func (d *deliverer) prepare(msg *Msg) {
+	msg.Flags |= MsgFlagsDurable | MsgFlagsRespExpected
+	msg.TimeoutMs = int64((d.ackTimeout + time.Millisecond - 1) / time.Millisecond)
+}
+
+// send stores the marshaled msg and schedules its first attempt.
+func (d *deliverer) send(target string, msg *Msg, data []byte) error {
+	handle, err := uuid.FromBytes(msg.GetHandle())
+	if err != nil {
+		return ErrBadHandle
+	}
+	m := &StoredMsg{Handle: handle, Target: target, Data: data, NextAttempt: time.Now()}
+	if err := d.store.Put(m); err != nil {
+		return err
+	}
+	d.schedule(m)
+	return nil
+}
+
+func (d *deliverer) schedule(m *StoredMsg) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stopped {
+		return
+	}
+	delay := time.Until(m.NextAttempt)
+	if delay < 0 {
+		delay = 0
+	}
+	d.wg.Add(1)
+	d.timers[m.Handle] = time.AfterFunc(delay, func() {
+		defer d.wg.Done()
+		d.lock.Lock()
+		delete(d.timers, m.Handle)
+		d.lock.Unlock()
+		d.attempt(m)
+	})
+}
+
+// attempt delivers the msg. The msg is deleted once acked, else it is
+// scheduled for redelivery or dead lettered.
+func (d *deliverer) attempt(m *StoredMsg) {
+	mb := d.mb
+	msg, err := Unmarshal(m.Data)
+	if err != nil {
+		d.store.Delete(m.Handle)
+		mb.drop(m.Target, nil, err)
+		return
+	}
+	m.Attempts++
+	msg.Attempt = int32(m.Attempts)
+	msg.GenerateHash()
+	data, err := Marshal(msg)
+	if err != nil {
+		d.store.Delete(m.Handle)
+		mb.drop(m.Target, msg, err)
+		return
+	}
+
+	start := time.Now()
+	r, err := mb.broker.SendAndWaitResponse(data, m.Target, m.Handle.String(), d.ackTimeout)
+	if err == nil {
+		var reply *Msg
+		if reply, err = Unmarshal(r); err == nil {
+			if err = reply.VerifyHash(); err == nil && !bytes.Equal(reply.GetHandle(), msg.GetHandle()) {
+				err = ErrRespMismatch
+			}
+		}
+	}
+	if err == nil {
+		mb.stats.count(m.Target, EventSent)
+		mb.stats.observeRoundTrip(m.Target, time.Since(start))
+		if err := d.store.Delete(m.Handle); err != nil {
+			d.logError(m, err, "failed to delete acked msg")
+		}
+		return
+	}
+
+	if err == ErrTimeout {
+		mb.stats.count(m.Target, EventTimedOut)
+	} else {
+		mb.stats.count(m.Target, EventFailed)
+	}
+	if d.maxAttempts > 0 && m.Attempts >= d.maxAttempts {
+		d.deadLetterMsg(m, msg, err)
+		return
+	}
+	m.NextAttempt = time.Now().Add(d.delay(m.Attempts))
+	if err := d.store.Put(m); err != nil {
+		d.logError(m, err, "failed to store msg")
+	}
+	mb.stats.count(m.Target, EventRedelivered)
+	d.schedule(m)
+}
+
+// delay returns the backoff after the failed attempts.
+func (d *deliverer) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
+
+// deadLetterMsg sends the msg to the dead letter target, if any, and deletes
+// it. The msg keeps the original target as its match so that the handler of
+// the dead letter target sees the original target. If the dead letter target
+// can't be reached the msg stays in the store for a last attempt after the
+// maximum backoff.
+func (d *deliverer) deadLetterMsg(m *StoredMsg, msg *Msg, cause error) {
+	mb := d.mb
+	if d.deadLetter != "" {
+		msg.Match = m.Target
+		msg.Flags &^= MsgFlagsDurable | MsgFlagsRespExpected
+		msg.TimeoutMs = 0
+		msg.GenerateHash()
+		data, err := Marshal(msg)
+		if err == nil {
+			err = mb.broker.Send(data, d.deadLetter)
+		}
+		if err != nil {
+			d.logError(m, err, "failed to dead letter msg")
+			m.Attempts--
+			m.NextAttempt = time.Now().Add(d.maxBackoff)
+			d.store.Put(m)
+			d.schedule(m)
+			return
+		}
+	}
+	mb.stats.count(m.Target, EventDeadLettered)
+	d.logError(m, cause, "dead lettered msg")
+	if err := d.store.Delete(m.Handle); err != nil {
+		d.logError(m, err, "failed to delete dead lettered msg")
+	}
+}
+
+func (d *deliverer) logError(m *StoredMsg, err error, msg string) {
+	if d.mb.ctx != nil {
+		d.mb.ctx.Log().Error().Error(err).Str("target", m.Target).Str("handle", m.Handle.String()).Msg(msg)
+	}
+}
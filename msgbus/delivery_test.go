@@ -0,0 +1,155 @@
+package msgbus
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDeliveryMsgbus(t *testing.T, config *DeliveryConfiguration, opts ...Option) *msgbus {
+	mb := newMsgbus(opts...)
+	mb.config.MsgbusType = InprocScheme
+	mb.config.MsgbusURI = "inproc://delivery"
+	mb.config.Delivery = config
+	assert.Nil(t, mb.Start(nil))
+	return mb
+}
+
+func TestDeliveryConfig(t *testing.T) {
+	for _, config := range []*DeliveryConfiguration{
+		{Backoff: "x"},
+		{AckTimeout: "0s"},
+		{Backoff: "1s", MaxBackoff: "10ms"},
+	} {
+		mb := newMsgbus()
+		mb.config.MsgbusType = InprocScheme
+		mb.config.Delivery = config
+		assert.Equal(t, ErrBadConfig, mb.Start(nil))
+	}
+}
+
+func TestDeliveryRedelivery(t *testing.T) {
+	mb := newDeliveryMsgbus(t, &DeliveryConfiguration{Backoff: "5ms", MaxBackoff: "20ms", AckTimeout: "1s"})
+	defer mb.Stop(nil)
+
+	attempts := make(chan int, 10)
+	assert.Nil(t, mb.RegisterDeliveryHandler("flaky", func(d Delivery, data []byte) {
+		attempts <- d.Attempt()
+		if d.Attempt() < 3 {
+			d.Nack(errors.New("not yet"))
+			return
+		}
+		// the msg can be acked after the handler returned
+		go d.Ack()
+	}))
+
+	assert.Nil(t, mb.Send([]byte("hello"), "flaky"))
+	assert.Equal(t, 1, <-attempts)
+	assert.Equal(t, 2, <-attempts)
+	assert.Equal(t, 3, <-attempts)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 0, len(attempts))
+	stats := mb.Stats()["flaky"]
+	assert.Equal(t, uint64(2), stats.Events[EventRedelivered])
+	assert.Equal(t, uint64(1), stats.Events[EventSent])
+
+	// the handlers of RegisterHandler ack by returning a nil error
+	rcvd := make(chan bool, 10)
+	var lock sync.Mutex
+	failed := false
+	assert.Nil(t, mb.RegisterHandler("once", func(ctx MsgContext, data []byte) ([]byte, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		rcvd <- ctx.ResponseExpected()
+		if !failed {
+			failed = true
+			return nil, errors.New("first")
+		}
+		return nil, nil
+	}))
+	assert.Nil(t, mb.Send([]byte("hello"), "once"))
+	assert.False(t, <-rcvd)
+	assert.False(t, <-rcvd)
+}
+
+func TestDeliveryDeadLetter(t *testing.T) {
+	mb := newDeliveryMsgbus(t, &DeliveryConfiguration{MaxAttempts: 3, Backoff: "1ms", MaxBackoff: "2ms", AckTimeout: "20ms", DeadLetter: "dlq"})
+	defer mb.Stop(nil)
+
+	dead := make(chan string, 10)
+	assert.Nil(t, mb.RegisterHandler("dlq", func(ctx MsgContext, data []byte) ([]byte, error) {
+		dead <- ctx.Target() + ":" + string(data)
+		return nil, nil
+	}))
+	assert.Nil(t, mb.RegisterDeliveryHandler("poison", func(d Delivery, data []byte) {
+		d.Nack(nil)
+	}))
+	// the msgs neither acked nor nacked time out
+	assert.Nil(t, mb.RegisterDeliveryHandler("silent", func(d Delivery, data []byte) {}))
+
+	assert.Nil(t, mb.Send([]byte("hello"), "poison"))
+	assert.Equal(t, "poison:hello", <-dead)
+	assert.Nil(t, mb.Send([]byte("hello"), "silent"))
+	assert.Equal(t, "silent:hello", <-dead)
+
+	stats := mb.Stats()
+	assert.Equal(t, uint64(3), stats["poison"].Events[EventReceived])
+	assert.Equal(t, uint64(2), stats["poison"].Events[EventRedelivered])
+	assert.Equal(t, uint64(1), stats["poison"].Events[EventDeadLettered])
+	assert.Equal(t, uint64(3), stats["silent"].Events[EventTimedOut])
+	assert.Equal(t, uint64(1), stats["silent"].Events[EventDeadLettered])
+}
+
+func TestDeliveryFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cube-msgbus")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	config := &DeliveryConfiguration{Store: dir, Backoff: "5ms", MaxBackoff: "10ms", MaxAttempts: -1}
+
+	// nobody handles the target yet
+	mb := newDeliveryMsgbus(t, config)
+	assert.Nil(t, mb.Send([]byte("hello"), "later"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, mb.Stop(nil))
+	files, err := filepath.Glob(filepath.Join(dir, "*"+msgFileExt))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+
+	// the msg is delivered once the msgbus restarts with a handler
+	mb = newDeliveryMsgbus(t, config)
+	defer mb.Stop(nil)
+	rcvd := make(chan int, 10)
+	assert.Nil(t, mb.RegisterDeliveryHandler("later", func(d Delivery, data []byte) {
+		rcvd <- d.Attempt()
+		d.Ack()
+	}))
+	assert.True(t, <-rcvd > 1)
+	time.Sleep(20 * time.Millisecond)
+	files, err = filepath.Glob(filepath.Join(dir, "*"+msgFileExt))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestDeliveryWithStore(t *testing.T) {
+	store := NewMemStore()
+	mb := newDeliveryMsgbus(t, &DeliveryConfiguration{}, WithStore(store))
+	defer mb.Stop(nil)
+	assert.Equal(t, store, mb.deliver.store)
+
+	// the msgs sent without the delivery are acked once handled
+	done := make(chan struct{})
+	plain := newInprocMsgbus(t, "inproc://delivery")
+	defer plain.Stop(nil)
+	assert.Nil(t, mb.RegisterDeliveryHandler("plain", func(d Delivery, data []byte) {
+		assert.Equal(t, 1, d.Attempt())
+		close(done)
+	}))
+	assert.Nil(t, plain.Send([]byte("hello"), "plain"))
+	<-done
+}
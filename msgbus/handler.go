@@ -0,0 +1,119 @@
+package msgbus
+
+import (
+	"context"
+	"time"
+
+	"github.com/anuvu/cube/component"
+	"github.com/anuvu/zlog"
+	"github.com/satori/go.uuid"
+)
+
+// MsgContext is the context of a msg delivered to a msg handler. The context
+// is cancelled when the msgbus stops or when the deadline derived from the
+// timeout of the sender expires. The logger is tagged with the target and the
+// handle of the msg. For the msgs delivered to a subscription the target is the
+// topic the msg was published on.
+type MsgContext interface {
+	component.Context
+	Handle() uuid.UUID
+	Target() string
+	ResponseExpected() bool
+}
+
+// Handler handles the msgs delivered to a target. If the sender expects a
+// response the handler must return a non empty response.
+type Handler func(ctx MsgContext, data []byte) ([]byte, error)
+
+// AdaptMsgHandler adapts a msg handler that takes the payload and whether a
+// response is expected to a Handler.
+func AdaptMsgHandler(h func([]byte, bool) ([]byte, error)) Handler {
+	return func(ctx MsgContext, data []byte) ([]byte, error) {
+		return h(data, ctx.ResponseExpected())
+	}
+}
+
+type msgContext struct {
+	ctx          context.Context
+	log          zlog.Logger
+	handle       uuid.UUID
+	target       string
+	respExpected bool
+	durable      bool
+	attempt      int
+}
+
+// newMsgContext returns the context of the msg derived from the parent context.
+// The returned cancel function must be called once the msg is handled.
+func newMsgContext(parent component.Context, target string, msg *Msg) (*msgContext, context.CancelFunc) {
+	c, log := context.Background(), zlog.Logger(nil)
+	if parent != nil {
+		c, log = parent.Ctx(), parent.Log()
+	}
+	if log == nil {
+		log = zlog.New("msgbus")
+	}
+
+	var cancel context.CancelFunc
+	if t := msg.GetTimeoutMs(); t > 0 {
+		c, cancel = context.WithTimeout(c, time.Duration(t)*time.Millisecond)
+	} else {
+		c, cancel = context.WithCancel(c)
+	}
+
+	if topic := msg.GetMatch(); topic != "" {
+		target = topic
+	}
+	handle, _ := uuid.FromBytes(msg.GetHandle())
+	// the response to a durable msg is the ack of the handler
+	durable := msg.GetFlags()&MsgFlagsDurable == MsgFlagsDurable
+	mc := &msgContext{
+		ctx:          c,
+		handle:       handle,
+		target:       target,
+		respExpected: msg.GetFlags()&MsgFlagsRespExpected == MsgFlagsRespExpected && !durable,
+		durable:      durable,
+		attempt:      1,
+	}
+	if a := msg.GetAttempt(); a > 0 {
+		mc.attempt = int(a)
+	}
+	mc.log = &msgLogger{Logger: log, target: target, handle: handle.String()}
+	return mc, cancel
+}
+
+func (mc *msgContext) Ctx() context.Context {
+	return mc.ctx
+}
+
+func (mc *msgContext) Log() zlog.Logger {
+	return mc.log
+}
+
+func (mc *msgContext) Handle() uuid.UUID {
+	return mc.handle
+}
+
+func (mc *msgContext) Target() string {
+	return mc.target
+}
+
+func (mc *msgContext) ResponseExpected() bool {
+	return mc.respExpected
+}
+
+// msgLogger tags the info and error events with the target and the handle of
+// the msg.
+type msgLogger struct {
+	zlog.Logger
+	target string
+	handle string
+}
+
+func (l *msgLogger) Info() zlog.Event {
+	return l.Logger.Info().Str("target", l.target).Str("handle", l.handle)
+}
+
+func (l *msgLogger) Error() zlog.Event {
+	return l.Logger.Error().Str("target", l.target).Str("handle", l.handle)
+}
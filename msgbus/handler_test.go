@@ -0,0 +1,55 @@
+package msgbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerContext(t *testing.T) {
+	mb := newMsgbus()
+	mb.config.MsgbusType = "mock"
+	assert.Nil(t, mb.Start(nil))
+	defer mb.Stop(nil)
+
+	ctxs := make(chan MsgContext, 1)
+	assert.Nil(t, mb.RegisterHandler("ctx", func(ctx MsgContext, data []byte) ([]byte, error) {
+		ctx.Log().Info().Msg("handling msg")
+		ctxs <- ctx
+		return data, nil
+	}))
+
+	// a request carries the deadline of the sender
+	start := time.Now()
+	_, u, err := mb.SendAndWaitResponse([]byte("hello"), "ctx", time.Second)
+	assert.Nil(t, err)
+	ctx := <-ctxs
+	assert.Equal(t, u, ctx.Handle())
+	assert.Equal(t, "ctx", ctx.Target())
+	assert.True(t, ctx.ResponseExpected())
+	deadline, ok := ctx.Ctx().Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, start.Add(time.Second), deadline, 100*time.Millisecond)
+	// the context is cancelled once the msg is handled
+	assert.Equal(t, context.Canceled, ctx.Ctx().Err())
+
+	// a msg without a timeout has no deadline
+	assert.Nil(t, mb.Send([]byte("hello"), "ctx"))
+	ctx = <-ctxs
+	assert.False(t, ctx.ResponseExpected())
+	_, ok = ctx.Ctx().Deadline()
+	assert.False(t, ok)
+
+	// the handlers taking the payload are adapted
+	rcvd := make(chan bool, 1)
+	assert.Nil(t, mb.RegisterMsgHandler("plain", func(data []byte, respReqd bool) ([]byte, error) {
+		rcvd <- respReqd
+		return data, nil
+	}))
+	r, _, err := mb.SendAndWaitResponse([]byte("hello"), "plain", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), r)
+	assert.True(t, <-rcvd)
+}
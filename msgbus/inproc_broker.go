@@ -0,0 +1,455 @@
+package msgbus
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anuvu/cube/component"
+)
+
+var (
+	// ErrQueueFull the queue of the target stayed full for the send timeout
+	ErrQueueFull = errors.New("broker: queue full")
+)
+
+const (
+	// InprocScheme is the URI scheme of the inproc broker
+	InprocScheme = "inproc"
+	// DefaultInprocQueueSize is the default number of msgs queued per target
+	DefaultInprocQueueSize = 128
+	// DefaultInprocWorkers is the default number of workers handling the msgs
+	// of a target
+	DefaultInprocWorkers = 4
+	// DefaultInprocSendTimeout is the default time a send waits for room in a
+	// full queue
+	DefaultInprocSendTimeout = time.Second
+)
+
+// inprocNetwork routes the msgs between the inproc brokers of the same name.
+type inprocNetwork struct {
+	lock    sync.Mutex
+	targets map[string]*inprocQueue
+	subs    []*inprocSub
+	queues  map[string]int
+}
+
+var (
+	inprocLock     sync.Mutex
+	inprocNetworks = map[string]*inprocNetwork{}
+)
+
+func joinInprocNetwork(name string) *inprocNetwork {
+	inprocLock.Lock()
+	defer inprocLock.Unlock()
+	n, ok := inprocNetworks[name]
+	if !ok {
+		n = &inprocNetwork{targets: map[string]*inprocQueue{}, queues: map[string]int{}}
+		inprocNetworks[name] = n
+	}
+	return n
+}
+
+// inprocResult is the outcome of a handled msg.
+type inprocResult struct {
+	data []byte
+	err  error
+}
+
+// inprocDelivery is a msg queued for a target. The reply channel is nil if no
+// response is expected.
+type inprocDelivery struct {
+	data  []byte
+	reply chan inprocResult
+}
+
+// inprocQueue is the bounded queue of a target or a subscription served by a
+// pool of workers.
+type inprocQueue struct {
+	ch      chan inprocDelivery
+	done    chan struct{}
+	handler BrokerHandler
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func newInprocQueue(h BrokerHandler, size, workers int) *inprocQueue {
+	q := &inprocQueue{ch: make(chan inprocDelivery, size), done: make(chan struct{}), handler: h}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *inprocQueue) work() {
+	defer q.wg.Done()
+	for {
+		select {
+		case d := <-q.ch:
+			r, err := q.handler(d.data)
+			if d.reply != nil {
+				d.reply <- inprocResult{r, err}
+			}
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// enqueue queues the msg, waiting up to the timeout for room in the queue.
+func (q *inprocQueue) enqueue(d inprocDelivery, timeout time.Duration) error {
+	select {
+	case q.ch <- d:
+		return nil
+	case <-q.done:
+		return ErrSubNotFound
+	default:
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case q.ch <- d:
+		return nil
+	case <-q.done:
+		return ErrSubNotFound
+	case <-t.C:
+		return ErrQueueFull
+	}
+}
+
+// close stops the workers once they are done with the msgs being handled. It
+// does not wait for the workers so that a handler can unregister its own
+// target or stop its msgbus. The requests still queued fail once the workers
+// stopped.
+func (q *inprocQueue) close() {
+	q.once.Do(func() {
+		close(q.done)
+		go func() {
+			q.wg.Wait()
+			for {
+				select {
+				case d := <-q.ch:
+					if d.reply != nil {
+						d.reply <- inprocResult{nil, ErrSubNotFound}
+					}
+				default:
+					return
+				}
+			}
+		}()
+	})
+}
+
+type inprocBroker struct {
+	network     *inprocNetwork
+	name        string
+	queueSize   int
+	workers     int
+	sendTimeout time.Duration
+	lock        sync.Mutex
+	registered  bool
+	targets     map[string]*inprocQueue
+	subs        map[*inprocSub]bool
+}
+
+type inprocSub struct {
+	broker  *inprocBroker
+	pattern string
+	queue   string
+	q       *inprocQueue
+}
+
+// newInprocBroker returns a broker that routes the msgs to the brokers of the
+// same process. The brokers whose URIs have the same host share the targets
+// and topics. The queue_size, workers and send_timeout query parameters of the
+// URI tune the queues of the targets, e.g.
+// "inproc://services?queue_size=64&workers=8&send_timeout=500ms".
+func newInprocBroker(config *Configuration) (Broker, error) {
+	b := &inprocBroker{
+		queueSize:   DefaultInprocQueueSize,
+		workers:     DefaultInprocWorkers,
+		sendTimeout: DefaultInprocSendTimeout,
+		targets:     map[string]*inprocQueue{},
+		subs:        map[*inprocSub]bool{},
+	}
+	if config.MsgbusURI == "" {
+		return b, nil
+	}
+
+	u, err := url.Parse(config.MsgbusURI)
+	if err != nil || u.Scheme != InprocScheme {
+		return nil, ErrBadConfig
+	}
+	b.name = u.Host
+	q := u.Query()
+	if v := q.Get("queue_size"); v != "" {
+		if b.queueSize, err = strconv.Atoi(v); err != nil || b.queueSize < 0 {
+			return nil, ErrBadConfig
+		}
+	}
+	if v := q.Get("workers"); v != "" {
+		if b.workers, err = strconv.Atoi(v); err != nil || b.workers < 1 {
+			return nil, ErrBadConfig
+		}
+	}
+	if v := q.Get("send_timeout"); v != "" {
+		if b.sendTimeout, err = time.ParseDuration(v); err != nil || b.sendTimeout < 0 {
+			return nil, ErrBadConfig
+		}
+	}
+	return b, nil
+}
+
+func (b *inprocBroker) Register() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.registered {
+		return ErrDupConn
+	}
+	b.network = joinInprocNetwork(b.name)
+	b.registered = true
+	return nil
+}
+
+// Unregister removes the targets and the subscriptions of the broker from the
+// network and stops their workers.
+func (b *inprocBroker) Unregister() error {
+	b.lock.Lock()
+	if !b.registered {
+		b.lock.Unlock()
+		return ErrBadBroker
+	}
+	b.registered = false
+	targets, subs := b.targets, b.subs
+	b.targets, b.subs = map[string]*inprocQueue{}, map[*inprocSub]bool{}
+	b.lock.Unlock()
+
+	for target, q := range targets {
+		b.network.removeTarget(target, q)
+	}
+	for sub := range subs {
+		b.network.removeSub(sub)
+	}
+	return nil
+}
+
+func (b *inprocBroker) RegisterMsgHandler(target string, msgHandler func([]byte, bool) ([]byte, error)) error {
+	return b.RegisterBrokerHandler(target, PayloadHandler(msgHandler))
+}
+
+func (b *inprocBroker) RegisterBrokerHandler(target string, msgHandler BrokerHandler) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.registered {
+		return ErrBadBroker
+	}
+
+	n := b.network
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.targets[target] != nil {
+		return ErrDupSub
+	}
+	q := newInprocQueue(msgHandler, b.queueSize, b.workers)
+	n.targets[target] = q
+	b.targets[target] = q
+	return nil
+}
+
+func (b *inprocBroker) UnregisterMsgHandler(target string) error {
+	b.lock.Lock()
+	q, ok := b.targets[target]
+	delete(b.targets, target)
+	b.lock.Unlock()
+	if !ok {
+		return ErrSubNotFound
+	}
+	b.network.removeTarget(target, q)
+	return nil
+}
+
+func (b *inprocBroker) Send(data []byte, target string) error {
+	q, err := b.target(target)
+	if err != nil {
+		return err
+	}
+	return q.enqueue(inprocDelivery{data: data}, b.sendTimeout)
+}
+
+func (b *inprocBroker) SendAndWaitResponse(data []byte, target string, handle string, timeout time.Duration) ([]byte, error) {
+	q, err := b.target(target)
+	if err != nil {
+		return nil, err
+	}
+
+	// timeout chan, because timeout can be zero/disabled
+	var tch <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		tch = t.C
+	}
+
+	// the reply is buffered so that the workers never block on the late replies
+	reply := make(chan inprocResult, 1)
+	sendTimeout := b.sendTimeout
+	if timeout > 0 && timeout < sendTimeout {
+		sendTimeout = timeout
+	}
+	if err := q.enqueue(inprocDelivery{data: data, reply: reply}, sendTimeout); err == ErrQueueFull && sendTimeout == timeout {
+		return nil, ErrTimeout
+	} else if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-reply:
+		return r.data, r.err
+	case <-tch:
+		return nil, ErrTimeout
+	case <-q.done:
+		// the target went away, unless it replied while closing
+		select {
+		case r := <-reply:
+			return r.data, r.err
+		default:
+			return nil, ErrSubNotFound
+		}
+	}
+}
+
+func (b *inprocBroker) Subscribe(pattern string, queue string, msgHandler BrokerHandler) (Subscription, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.registered {
+		return nil, ErrBadBroker
+	}
+
+	sub := &inprocSub{broker: b, pattern: pattern, queue: queue, q: newInprocQueue(msgHandler, b.queueSize, b.workers)}
+	n := b.network
+	n.lock.Lock()
+	n.subs = append(n.subs, sub)
+	n.lock.Unlock()
+	b.subs[sub] = true
+	return sub, nil
+}
+
+// Publish queues the msg for every subscription matching the topic. The
+// queues that stay full for the send timeout are skipped and reported in a
+// component.MultiError if there are several.
+func (b *inprocBroker) Publish(data []byte, topic string) error {
+	n, err := b.net()
+	if err != nil {
+		return err
+	}
+
+	// every plain subscriber gets the msg while the members of a queue group
+	// take turns
+	n.lock.Lock()
+	queues := []*inprocQueue{}
+	groups := map[string][]*inprocSub{}
+	for _, sub := range n.subs {
+		if !MatchTopic(sub.pattern, topic) {
+			continue
+		}
+		if sub.queue == "" {
+			queues = append(queues, sub.q)
+		} else {
+			groups[sub.queue] = append(groups[sub.queue], sub)
+		}
+	}
+	for queue, members := range groups {
+		queues = append(queues, members[n.queues[queue]%len(members)].q)
+		n.queues[queue]++
+	}
+	n.lock.Unlock()
+
+	errs := component.MultiError{}
+	for _, q := range queues {
+		if err := q.enqueue(inprocDelivery{data: data}, b.sendTimeout); err != nil && err != ErrSubNotFound {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// net returns the network of the broker if it is registered.
+func (b *inprocBroker) net() (*inprocNetwork, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.registered {
+		return nil, ErrBadBroker
+	}
+	return b.network, nil
+}
+
+// target returns the queue of the target.
+func (b *inprocBroker) target(target string) (*inprocQueue, error) {
+	n, err := b.net()
+	if err != nil {
+		return nil, err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	q, ok := n.targets[target]
+	if !ok {
+		return nil, ErrSubNotFound
+	}
+	return q, nil
+}
+
+func (sub *inprocSub) Pattern() string {
+	return sub.pattern
+}
+
+func (sub *inprocSub) Queue() string {
+	return sub.queue
+}
+
+func (sub *inprocSub) Unsubscribe() error {
+	b := sub.broker
+	b.lock.Lock()
+	ok := b.subs[sub]
+	delete(b.subs, sub)
+	b.lock.Unlock()
+	if !ok {
+		return ErrSubNotFound
+	}
+	b.network.removeSub(sub)
+	return nil
+}
+
+func (n *inprocNetwork) removeTarget(target string, q *inprocQueue) {
+	n.lock.Lock()
+	if n.targets[target] == q {
+		delete(n.targets, target)
+	}
+	n.lock.Unlock()
+	q.close()
+}
+
+func (n *inprocNetwork) removeSub(sub *inprocSub) {
+	n.lock.Lock()
+	for i, s := range n.subs {
+		if s == sub {
+			n.subs = append(n.subs[:i], n.subs[i+1:]...)
+			break
+		}
+	}
+	n.lock.Unlock()
+	sub.q.close()
+}
+
+func init() {
+	RegisterFactory(InprocScheme, newInprocBroker)
+}
@@ -0,0 +1,208 @@
+package msgbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newInprocMsgbus(t *testing.T, uri string) *msgbus {
+	mb := newMsgbus()
+	mb.config.MsgbusType = InprocScheme
+	mb.config.MsgbusURI = uri
+	assert.Nil(t, mb.Start(nil))
+	return mb
+}
+
+func TestInprocBrokerConfig(t *testing.T) {
+	for _, uri := range []string{
+		"tcp://localhost",
+		"inproc://test?queue_size=x",
+		"inproc://test?workers=0",
+		"inproc://test?send_timeout=1",
+		"inproc://test?send_timeout=-1s",
+	} {
+		_, err := newInprocBroker(&Configuration{MsgbusType: InprocScheme, MsgbusURI: uri})
+		assert.Equal(t, ErrBadConfig, err, uri)
+	}
+	b, err := newInprocBroker(&Configuration{MsgbusType: InprocScheme, MsgbusURI: "inproc://test?queue_size=1&workers=2&send_timeout=5ms"})
+	assert.Nil(t, err)
+	ib := b.(*inprocBroker)
+	assert.Equal(t, "test", ib.name)
+	assert.Equal(t, 1, ib.queueSize)
+	assert.Equal(t, 2, ib.workers)
+	assert.Equal(t, 5*time.Millisecond, ib.sendTimeout)
+
+	// an unregistered broker is unusable
+	assert.Equal(t, ErrBadBroker, b.Send([]byte("hello"), "target"))
+	assert.Equal(t, ErrBadBroker, b.Unregister())
+}
+
+func TestInprocBrokerRouting(t *testing.T) {
+	client := newInprocMsgbus(t, "inproc://routing")
+	server := newInprocMsgbus(t, "inproc://routing")
+	other := newInprocMsgbus(t, "inproc://other")
+	defer client.Stop(nil)
+	defer other.Stop(nil)
+
+	rcvd := make(chan string, 10)
+	assert.Nil(t, server.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		rcvd <- string(data)
+		return data, nil
+	}))
+	assert.Equal(t, ErrDupSub, client.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+
+	// msgs are routed between the msgbus instances of the same network only
+	assert.Nil(t, client.Send([]byte("hello"), "echo"))
+	assert.Equal(t, "hello", <-rcvd)
+	assert.Equal(t, ErrSubNotFound, other.Send([]byte("hello"), "echo"))
+
+	r, _, err := client.SendAndWaitResponse([]byte("sync"), "echo", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "sync", string(r))
+	<-rcvd
+
+	f, err := client.SendAsync(context.Background(), []byte("async"), "echo", time.Second)
+	assert.Nil(t, err)
+	r, err = f.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, "async", string(r))
+	<-rcvd
+
+	// subscribers on other msgbus instances get the published msgs
+	topics := make(chan string, 10)
+	_, err = server.Subscribe("events.>", func(ctx MsgContext, data []byte) ([]byte, error) {
+		topics <- ctx.Target()
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, client.Publish([]byte("hello"), "events.created"))
+	assert.Equal(t, "events.created", <-topics)
+
+	// the targets of a stopped msgbus are gone
+	assert.Nil(t, server.Stop(nil))
+	assert.Equal(t, ErrSubNotFound, client.Send([]byte("hello"), "echo"))
+	assert.Nil(t, client.Publish([]byte("hello"), "events.created"))
+	assert.Nil(t, client.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+}
+
+func TestInprocBrokerBackpressure(t *testing.T) {
+	client := newInprocMsgbus(t, "inproc://backpressure?queue_size=1&workers=1&send_timeout=20ms")
+	server := newInprocMsgbus(t, "inproc://backpressure")
+	defer client.Stop(nil)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	assert.Nil(t, server.RegisterHandler("slow", func(ctx MsgContext, data []byte) ([]byte, error) {
+		started <- struct{}{}
+		<-release
+		return data, nil
+	}))
+
+	// the queue of the target is set up by the server
+	assert.Nil(t, client.Send([]byte("hello"), "slow"))
+	<-started
+
+	// requests time out without any response
+	_, _, err := client.SendAndWaitResponse([]byte("hello"), "slow", 10*time.Millisecond)
+	assert.Equal(t, ErrTimeout, err)
+	close(release)
+	assert.Nil(t, server.Stop(nil))
+
+	// a full queue blocks the senders up to the send timeout
+	server = newInprocMsgbus(t, "inproc://backpressure?queue_size=1&workers=1")
+	defer server.Stop(nil)
+	// the handlers of the stopped msgbus may still be running
+	blocked, entered := make(chan struct{}), make(chan struct{}, 10)
+	assert.Nil(t, server.RegisterHandler("slow", func(ctx MsgContext, data []byte) ([]byte, error) {
+		entered <- struct{}{}
+		<-blocked
+		return data, nil
+	}))
+	assert.Nil(t, client.Send([]byte("hello"), "slow"))
+	<-entered
+	assert.Nil(t, client.Send([]byte("hello"), "slow"))
+	start := time.Now()
+	assert.Equal(t, ErrQueueFull, client.Send([]byte("hello"), "slow"))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	close(blocked)
+}
+
+func TestInprocBrokerPublishFull(t *testing.T) {
+	client := newInprocMsgbus(t, "inproc://publish?send_timeout=10ms")
+	server := newInprocMsgbus(t, "inproc://publish?queue_size=1&workers=1")
+	defer client.Stop(nil)
+	defer server.Stop(nil)
+
+	blocked, entered := make(chan struct{}), make(chan struct{}, 10)
+	defer close(blocked)
+	subscribe := func() {
+		_, err := server.Subscribe("events.>", func(ctx MsgContext, data []byte) ([]byte, error) {
+			entered <- struct{}{}
+			<-blocked
+			return nil, nil
+		})
+		assert.Nil(t, err)
+	}
+	subscribe()
+	assert.Nil(t, client.Publish([]byte("hello"), "events.created"))
+	<-entered
+	assert.Nil(t, client.Publish([]byte("hello"), "events.created"))
+
+	// the msg reaches the subscribers with room despite the full queue
+	rcvd := make(chan string, 10)
+	_, err := server.Subscribe("events.>", func(ctx MsgContext, data []byte) ([]byte, error) {
+		rcvd <- string(data)
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrQueueFull, client.Publish([]byte("full"), "events.created"))
+	assert.Equal(t, "full", <-rcvd)
+
+	// the failures of all the full queues are reported
+	subscribe()
+	assert.Equal(t, ErrQueueFull, client.Publish([]byte("hello"), "events.created"))
+	<-rcvd
+	<-entered
+	assert.Equal(t, ErrQueueFull, client.Publish([]byte("hello"), "events.created"))
+	<-rcvd
+	err = client.Publish([]byte("full"), "events.created")
+	assert.Len(t, err, 2)
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	assert.Equal(t, "full", <-rcvd)
+}
+
+func TestInprocBrokerHandlerCloses(t *testing.T) {
+	mb := newInprocMsgbus(t, "inproc://closes")
+	other := newInprocMsgbus(t, "inproc://closes")
+	defer other.Stop(nil)
+
+	// a handler can unregister its own target and stop its msgbus
+	done := make(chan error, 2)
+	assert.Nil(t, mb.RegisterHandler("once", func(ctx MsgContext, data []byte) ([]byte, error) {
+		done <- mb.UnregisterMsgHandler("once")
+		return nil, nil
+	}))
+	assert.Nil(t, mb.RegisterHandler("last", func(ctx MsgContext, data []byte) ([]byte, error) {
+		done <- mb.Stop(nil)
+		return nil, nil
+	}))
+	for _, target := range []string{"once", "last"} {
+		assert.Nil(t, other.Send([]byte("hello"), target))
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+		case <-time.After(time.Second):
+			t.Fatalf("the handler of %s deadlocked", target)
+		}
+	}
+	assert.Equal(t, ErrSubNotFound, other.Send([]byte("hello"), "once"))
+	assert.Equal(t, ErrSubNotFound, other.Send([]byte("hello"), "last"))
+}
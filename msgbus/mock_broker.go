@@ -2,17 +2,27 @@ package msgbus
 
 import (
 	"fmt"
+	"sync"
 	"time"
-
-	"github.com/satori/go.uuid"
 )
 
 type mockBroker struct {
-	subMap map[string]func([]byte, bool) ([]byte, error)
+	subMap map[string]BrokerHandler
+	// topic subscriptions and the round robin counters of the queue groups
+	lock   sync.Mutex
+	subs   []*mockSub
+	queues map[string]int
+}
+
+type mockSub struct {
+	mmb     *mockBroker
+	pattern string
+	queue   string
+	handler BrokerHandler
 }
 
 func newMockBroker(config *Configuration) (Broker, error) {
-	mmb := &mockBroker{subMap: make(map[string]func([]byte, bool) ([]byte, error))}
+	mmb := &mockBroker{subMap: make(map[string]BrokerHandler), queues: make(map[string]int)}
 	return mmb, nil
 }
 
@@ -33,6 +43,10 @@ func (mmb *mockBroker) Unregister() error {
 }
 
 func (mmb *mockBroker) RegisterMsgHandler(target string, msgHandler func([]byte, bool) ([]byte, error)) error {
+	return mmb.RegisterBrokerHandler(target, PayloadHandler(msgHandler))
+}
+
+func (mmb *mockBroker) RegisterBrokerHandler(target string, msgHandler BrokerHandler) error {
 	if mmb == nil {
 		return ErrBadBroker
 	}
@@ -68,13 +82,8 @@ func (mmb *mockBroker) Send(data []byte, target string) error {
 
 	msgHandler := mmb.subMap[target]
 	if msgHandler != nil {
-		msg, err := Unmarshal(data)
-		if err != nil {
-			panic(err)
-		}
-		msg.VerifyHash()
 		// call the handler
-		msgHandler(msg.GetPayload(), false)
+		msgHandler(data)
 	}
 
 	fmt.Printf("mock: sent %d bytes to target [%s]\n", len(data), target)
@@ -96,69 +105,108 @@ func (mmb *mockBroker) SendAndWaitResponse(data []byte, target string, handle st
 	}
 
 	// timeout chan, because timeout can be zero/disabled
-	tch := make(chan struct{}, 1)
+	var tch <-chan time.Time
+	deadline := time.Now().Add(timeout)
 	if timeout > 0 {
-		go func(timeout time.Duration) {
-			time.Sleep(timeout)
-			tch <- struct{}{}
-			close(tch)
-		}(timeout)
+		tch = time.After(timeout)
 	}
 
 	// FIXME: simulate a delay
 	time.Sleep(1 * time.Millisecond)
 
 	// this is a request-response msg, so subscribe a target on this particular msg handle
-	rch := make(chan []byte, 1)
+	type reply struct {
+		d   []byte
+		err error
+	}
+	rch := make(chan reply, 1)
 	go func() {
-		msg, err := Unmarshal(data)
-		if err != nil {
-			panic(err)
-		}
-		msg.VerifyHash()
 		// call the registered testMsgHandler
 		// FIXME: make this more robust by timing out on stuck msgHandler()
-		fmt.Printf("mock: received a message of %d bytes\n", len(msg.GetPayload()))
-		fmt.Printf("mock: received flags %d\n", msg.GetFlags())
-		respExpected := msg.GetFlags()&MsgFlagsRespExpected == MsgFlagsRespExpected
-		r, err := msgHandler(msg.GetPayload(), respExpected)
-		if err != nil {
-			panic(err)
-		}
-		// if a response was expected for this msg, a response was expected of the callback!
-		if respExpected {
-			if len(r) == 0 {
-				panic("invalid response")
-			}
-			rsp := msg.MakeReply(r)
-			u1, err := uuid.FromBytes(msg.GetHandle())
-			if err != nil {
-				panic("invalid msg handle")
-			}
-			d, err := Marshal(rsp)
-			if err != nil {
-				panic(err)
-			}
-
-			fmt.Printf("mock: sending reply to [%s]\n", u1.String())
-			rch <- d
-			close(rch)
-		}
+		fmt.Printf("mock: received a message of %d bytes\n", len(data))
+		d, err := msgHandler(data)
+		fmt.Printf("mock: sending reply to [%s]\n", handle)
+		rch <- reply{d, err}
 	}()
 
 	// now, block and wait for response
-	var r []byte
-	var err error
 	select {
-	case r = <-rch:
-		break
+	case r := <-rch:
+		// a response arriving after the deadline is dropped as a timeout
+		if timeout > 0 && time.Now().After(deadline) {
+			return nil, ErrTimeout
+		}
+		return r.d, r.err
 	case <-tch:
-		r = nil
-		err = ErrTimeout
-		break
+		return nil, ErrTimeout
+	}
+}
+
+func (mmb *mockBroker) Subscribe(pattern string, queue string, msgHandler BrokerHandler) (Subscription, error) {
+	if mmb == nil {
+		return nil, ErrBadBroker
+	}
+	sub := &mockSub{mmb: mmb, pattern: pattern, queue: queue, handler: msgHandler}
+	mmb.lock.Lock()
+	mmb.subs = append(mmb.subs, sub)
+	mmb.lock.Unlock()
+	fmt.Printf("mock: subscribed for topic [%s] queue [%s]\n", pattern, queue)
+	return sub, nil
+}
+
+func (mmb *mockBroker) Publish(data []byte, topic string) error {
+	if mmb == nil {
+		return ErrBadBroker
+	}
+
+	// every plain subscriber gets the msg while the members of a queue group
+	// take turns
+	mmb.lock.Lock()
+	handlers := []BrokerHandler{}
+	groups := map[string][]*mockSub{}
+	for _, sub := range mmb.subs {
+		if !MatchTopic(sub.pattern, topic) {
+			continue
+		}
+		if sub.queue == "" {
+			handlers = append(handlers, sub.handler)
+		} else {
+			groups[sub.queue] = append(groups[sub.queue], sub)
+		}
 	}
+	for queue, members := range groups {
+		handlers = append(handlers, members[mmb.queues[queue]%len(members)].handler)
+		mmb.queues[queue]++
+	}
+	mmb.lock.Unlock()
 
-	return r, err
+	for _, h := range handlers {
+		h(data)
+	}
+	fmt.Printf("mock: published %d bytes to %d subscribers of topic [%s]\n", len(data), len(handlers), topic)
+	return nil
+}
+
+func (sub *mockSub) Pattern() string {
+	return sub.pattern
+}
+
+func (sub *mockSub) Queue() string {
+	return sub.queue
+}
+
+func (sub *mockSub) Unsubscribe() error {
+	mmb := sub.mmb
+	mmb.lock.Lock()
+	defer mmb.lock.Unlock()
+	for i, s := range mmb.subs {
+		if s == sub {
+			mmb.subs = append(mmb.subs[:i], mmb.subs[i+1:]...)
+			fmt.Printf("mock: unsubscribed for topic [%s] queue [%s]\n", sub.pattern, sub.queue)
+			return nil
+		}
+	}
+	return ErrSubNotFound
 }
 
 func init() {
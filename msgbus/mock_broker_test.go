@@ -15,7 +15,7 @@ func TestMockBrokerBadCalls(t *testing.T) {
 
 	assert.NotNil(t, nmb.Register())
 	assert.NotNil(t, nmb.Unregister())
-	assert.NotNil(t, nmb.RegisterMsgHandler(target1, msgListener))
+	assert.NotNil(t, nmb.RegisterBrokerHandler(target1, func(d []byte) ([]byte, error) { return d, nil }))
 	assert.NotNil(t, nmb.UnregisterMsgHandler(target1))
 	assert.NotNil(t, nmb.Send([]byte(testMsg), target1))
 	_, e := nmb.SendAndWaitResponse([]byte(testMsg), target1, "", 0)
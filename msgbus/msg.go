@@ -3,9 +3,9 @@ package msgbus
 import (
 	"bytes"
 	"crypto/sha1"
-
 	"encoding/binary"
 	"errors"
+	"fmt"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/satori/go.uuid"
@@ -20,8 +20,43 @@ var (
 	ErrUnmarshal = errors.New("msg: unable to unmarshal")
 	// ErrHash hashing error
 	ErrHash = errors.New("msg: failed to verify hash")
+	// ErrBadHandle invalid msg handle
+	ErrBadHandle = errors.New("msg: invalid handle")
+	// ErrRespMismatch response does not match the request
+	ErrRespMismatch = errors.New("msg: response mismatch")
+	// ErrNilMsg nil msg
+	ErrNilMsg = errors.New("msg: nil msg")
 )
 
+// MsgError captures the failure to send, receive or verify a msg. Handle is
+// empty if the msg could not be decoded.
+type MsgError struct {
+	Target string
+	Handle string
+	Err    error
+}
+
+func (e *MsgError) Error() string {
+	if e.Handle == "" {
+		return fmt.Sprintf("%s: %v", e.Target, e.Err)
+	}
+	return fmt.Sprintf("%s [%s]: %v", e.Target, e.Handle, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *MsgError) Unwrap() error {
+	return e.Err
+}
+
+// newMsgError returns a MsgError for the msg of the target.
+func newMsgError(target string, msg *Msg, err error) *MsgError {
+	e := &MsgError{Target: target, Err: err}
+	if u, uerr := uuid.FromBytes(msg.GetHandle()); uerr == nil {
+		e.Handle = u.String()
+	}
+	return e
+}
+
 type msgFlags int32
 
 const (
@@ -29,6 +64,12 @@ const (
 	MsgFlagsEmpty msgFlags = 0
 	// MsgFlagsRespExpected indicates a response is needed for this msg
 	MsgFlagsRespExpected = 1 << iota
+	// MsgFlagsError indicates the payload of the response is the error of the
+	// msg handler
+	MsgFlagsError
+	// MsgFlagsDurable indicates the msg is delivered at least once, the
+	// response is the ack of the handler
+	MsgFlagsDurable
 	// MsgFlagsMax is a sentinel
 	MsgFlagsMax
 )
@@ -41,60 +82,78 @@ func newMsg(payload []byte) *Msg {
 }
 
 // MakeReply a reply msg using called msg
-func (msg *Msg) MakeReply(payload []byte) *Msg {
+func (msg *Msg) MakeReply(payload []byte) (*Msg, error) {
 	if msg == nil {
-		panic("invalid msg")
+		return nil, ErrNilMsg
 	}
 	r := new(Msg)
 	*r = *msg
 	r.Payload = payload
 	r.GenerateHash()
-	return r
+	return r, nil
 }
 
 // Marshal a msg into a byte array
 func Marshal(msg *Msg) ([]byte, error) {
+	if msg == nil {
+		return nil, ErrNilMsg
+	}
 	d, err := proto.Marshal(msg)
-	return d, err
+	if err != nil {
+		return nil, ErrMarshal
+	}
+	return d, nil
 }
 
 // Unmarshal a byte array into a msg
 func Unmarshal(d []byte) (*Msg, error) {
 	m := &Msg{}
-	err := proto.Unmarshal(d, m)
-	return m, err
+	if err := proto.Unmarshal(d, m); err != nil {
+		return nil, ErrUnmarshal
+	}
+	return m, nil
 }
 
 // GenerateHash creates an hash of the msg
 func (msg *Msg) GenerateHash() {
-	h := sha1.New()
-	h.Write(msg.Payload)
-	h.Write(msg.Handle)
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, msg.Flags)
-	if err != nil {
-		panic(err)
-	}
-	h.Write(buf.Bytes())
-	hash := h.Sum(nil)
-	msg.Hash = hash
-	//msg.dump()
+	msg.Hash = msg.hash()
 }
 
 // VerifyHash verifies the msg hash
-func (msg *Msg) VerifyHash() {
-	//msg.dump()
+func (msg *Msg) VerifyHash() error {
+	if !msg.validHash() {
+		return ErrHash
+	}
+	return nil
+}
+
+// validHash returns true if the msg hash matches its contents
+func (msg *Msg) validHash() bool {
+	return bytes.Equal(msg.hash(), msg.GetHash())
+}
+
+// hash computes the hash of all the fields of the msg but the hash. Every
+// variable length field is prefixed with its length so that no field can be
+// shifted into the next one.
+func (msg *Msg) hash() []byte {
 	h := sha1.New()
-	h.Write(msg.Payload)
-	h.Write(msg.Handle)
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, msg.Flags)
-	if err != nil {
-		panic(err)
+	n := make([]byte, 8)
+	num := func(v uint64) {
+		binary.LittleEndian.PutUint64(n, v)
+		h.Write(n)
 	}
-	h.Write(buf.Bytes())
-	hash := h.Sum(nil)
-	if !bytes.Equal(hash, msg.GetHash()) {
-		panic("invalid hash")
+	field := func(d []byte) {
+		num(uint64(len(d)))
+		h.Write(d)
 	}
+	field([]byte(msg.GetMatch()))
+	field(msg.GetPayload())
+	field(msg.GetHandle())
+	num(uint64(uint32(msg.GetFlags())))
+	field([]byte(msg.GetTraceparent()))
+	field([]byte(msg.GetTracestate()))
+	num(uint64(msg.GetTimeoutMs()))
+	field([]byte(msg.GetReplyTo()))
+	num(uint64(uint32(msg.GetAttempt())))
+	return h.Sum(nil)
 }
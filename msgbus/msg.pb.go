@@ -28,11 +28,16 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type Msg struct {
-	Match   string `protobuf:"bytes,1,opt,name=match" json:"match,omitempty"`
-	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
-	Handle  []byte `protobuf:"bytes,3,opt,name=handle,proto3" json:"handle,omitempty"`
-	Flags   int32  `protobuf:"varint,4,opt,name=flags" json:"flags,omitempty"`
-	Hash    []byte `protobuf:"bytes,5,opt,name=hash,proto3" json:"hash,omitempty"`
+	Match       string `protobuf:"bytes,1,opt,name=match" json:"match,omitempty"`
+	Payload     []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	Handle      []byte `protobuf:"bytes,3,opt,name=handle,proto3" json:"handle,omitempty"`
+	Flags       int32  `protobuf:"varint,4,opt,name=flags" json:"flags,omitempty"`
+	Hash        []byte `protobuf:"bytes,5,opt,name=hash,proto3" json:"hash,omitempty"`
+	Traceparent string `protobuf:"bytes,6,opt,name=traceparent" json:"traceparent,omitempty"`
+	Tracestate  string `protobuf:"bytes,7,opt,name=tracestate" json:"tracestate,omitempty"`
+	TimeoutMs   int64  `protobuf:"varint,8,opt,name=timeout_ms,json=timeoutMs" json:"timeout_ms,omitempty"`
+	ReplyTo     string `protobuf:"bytes,9,opt,name=reply_to,json=replyTo" json:"reply_to,omitempty"`
+	Attempt     int32  `protobuf:"varint,10,opt,name=attempt" json:"attempt,omitempty"`
 }
 
 func (m *Msg) Reset()                    { *m = Msg{} }
@@ -75,6 +80,41 @@ func (m *Msg) GetHash() []byte {
 	return nil
 }
 
+func (m *Msg) GetTraceparent() string {
+	if m != nil {
+		return m.Traceparent
+	}
+	return ""
+}
+
+func (m *Msg) GetTracestate() string {
+	if m != nil {
+		return m.Tracestate
+	}
+	return ""
+}
+
+func (m *Msg) GetTimeoutMs() int64 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+func (m *Msg) GetReplyTo() string {
+	if m != nil {
+		return m.ReplyTo
+	}
+	return ""
+}
+
+func (m *Msg) GetAttempt() int32 {
+	if m != nil {
+		return m.Attempt
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Msg)(nil), "msgbus.msg")
 }
@@ -82,14 +122,19 @@ func init() {
 func init() { proto.RegisterFile("msg.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 138 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xcc, 0x2d, 0x4e, 0xd7,
-	0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0xcb, 0x2d, 0x4e, 0x4f, 0x2a, 0x2d, 0x56, 0x2a, 0xe7,
-	0x62, 0xce, 0x2d, 0x4e, 0x17, 0x12, 0xe1, 0x62, 0xcd, 0x4d, 0x2c, 0x49, 0xce, 0x90, 0x60, 0x54,
-	0x60, 0xd4, 0xe0, 0x0c, 0x82, 0x70, 0x84, 0x24, 0xb8, 0xd8, 0x0b, 0x12, 0x2b, 0x73, 0xf2, 0x13,
-	0x53, 0x24, 0x98, 0x14, 0x18, 0x35, 0x78, 0x82, 0x60, 0x5c, 0x21, 0x31, 0x2e, 0xb6, 0x8c, 0xc4,
-	0xbc, 0x94, 0x9c, 0x54, 0x09, 0x66, 0xb0, 0x04, 0x94, 0x07, 0x32, 0x27, 0x2d, 0x27, 0x31, 0xbd,
-	0x58, 0x82, 0x45, 0x81, 0x51, 0x83, 0x35, 0x08, 0xc2, 0x11, 0x12, 0xe2, 0x62, 0xc9, 0x48, 0x2c,
-	0xce, 0x90, 0x60, 0x05, 0xab, 0x05, 0xb3, 0x93, 0xd8, 0xc0, 0xee, 0x30, 0x06, 0x04, 0x00, 0x00,
-	0xff, 0xff, 0x75, 0x79, 0xb2, 0x40, 0x94, 0x00, 0x00, 0x00,
+	// 217 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xbf, 0x4e, 0xc3, 0x40,
+	0x0c, 0xc6, 0x75, 0x4d, 0x93, 0x34, 0x86, 0xc9, 0x42, 0xc8, 0x0c, 0xa0, 0x13, 0x53, 0x26, 0x16,
+	0x9e, 0x83, 0x25, 0x62, 0xaf, 0xdc, 0xf6, 0x48, 0x90, 0x72, 0xbd, 0xd3, 0xd9, 0x1d, 0xfa, 0x10,
+	0xbc, 0x33, 0xaa, 0xdb, 0x4a, 0xd9, 0xfc, 0xfb, 0xfc, 0xf9, 0x2f, 0x74, 0x51, 0xc6, 0x8f, 0x5c,
+	0x92, 0x26, 0x6c, 0xa2, 0x8c, 0xbb, 0x93, 0xbc, 0xff, 0xad, 0xa0, 0x8a, 0x32, 0xe2, 0x13, 0xd4,
+	0x91, 0x75, 0x3f, 0x91, 0xf3, 0xae, 0xef, 0x86, 0x2b, 0x20, 0x41, 0x9b, 0xf9, 0x3c, 0x27, 0x3e,
+	0xd0, 0xca, 0xbb, 0xfe, 0x71, 0xb8, 0x23, 0x3e, 0x43, 0x33, 0xf1, 0xf1, 0x30, 0x07, 0xaa, 0x2c,
+	0x71, 0xa3, 0x4b, 0x9f, 0x9f, 0x99, 0x47, 0xa1, 0xb5, 0x77, 0x7d, 0x3d, 0x5c, 0x01, 0x11, 0xd6,
+	0x13, 0xcb, 0x44, 0xb5, 0x79, 0x2d, 0x46, 0x0f, 0x0f, 0x5a, 0x78, 0x1f, 0x32, 0x97, 0x70, 0x54,
+	0x6a, 0x6c, 0xee, 0x52, 0xc2, 0x37, 0x00, 0x43, 0x51, 0xd6, 0x40, 0xad, 0x19, 0x16, 0x0a, 0xbe,
+	0x02, 0xe8, 0x6f, 0x0c, 0xe9, 0xa4, 0xdb, 0x28, 0xb4, 0xf1, 0xae, 0xaf, 0x86, 0xee, 0xa6, 0x7c,
+	0x09, 0xbe, 0xc0, 0xa6, 0x84, 0x3c, 0x9f, 0xb7, 0x9a, 0xa8, 0xb3, 0xe2, 0xd6, 0xf8, 0x3b, 0x5d,
+	0xee, 0x62, 0xd5, 0x10, 0xb3, 0x12, 0xd8, 0x9e, 0x77, 0xdc, 0x35, 0xf6, 0x9e, 0xcf, 0xff, 0x01,
+	0x00, 0x3c, 0xba, 0xab, 0xc5, 0x2b, 0x01, 0x00, 0x00,
 }
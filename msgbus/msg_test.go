@@ -1,18 +1,102 @@
 package msgbus
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
+	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMsgBadCalls(t *testing.T) {
 	var m *Msg
 
-	assert.Panics(t, func() { m.MakeReply([]byte("")) }, "should panic")
+	_, err := m.MakeReply([]byte(""))
+	assert.Equal(t, ErrNilMsg, err)
+	_, err = Marshal(m)
+	assert.Equal(t, ErrNilMsg, err)
+	_, err = Unmarshal([]byte{0xff})
+	assert.Equal(t, ErrUnmarshal, err)
+
+	// a tampered msg fails the hash verification
+	m = newMsg([]byte("hello"))
+	m.GenerateHash()
+	assert.Nil(t, m.VerifyHash())
+	m.Payload = []byte("jello")
+	assert.Equal(t, ErrHash, m.VerifyHash())
+	assert.Equal(t, ErrHash, (*Msg)(nil).VerifyHash())
+
+	// every field but the hash is covered
+	for _, tamper := range []func(m *Msg){
+		func(m *Msg) { m.Match = "other" },
+		func(m *Msg) { m.Handle = uuid.NewV4().Bytes() },
+		func(m *Msg) { m.Flags |= MsgFlagsError },
+		func(m *Msg) { m.Traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" },
+		func(m *Msg) { m.Tracestate = "k=v" },
+		func(m *Msg) { m.TimeoutMs = 10 },
+		func(m *Msg) { m.ReplyTo = "_inbox.other" },
+		func(m *Msg) { m.Attempt = 2 },
+		// the length prefixes keep the bytes from moving across fields
+		func(m *Msg) { m.Match, m.Payload = "h", []byte("ello") },
+	} {
+		m = newMsg([]byte("hello"))
+		m.GenerateHash()
+		tamper(m)
+		assert.Equal(t, ErrHash, m.VerifyHash())
+	}
+}
+
+func TestBadMsgs(t *testing.T) {
+	mb := newMsgbus()
+	mb.config.MsgbusType = "mock"
+	assert.Nil(t, mb.Start(nil))
+	defer mb.Stop(nil)
+
+	// undecodable and tampered msgs are counted and dropped
+	receive := mb.receive("bad", AdaptMsgHandler(func(data []byte, respReqd bool) ([]byte, error) {
+		t.Fatal("bad msg delivered to the handler")
+		return nil, nil
+	}))
+	_, err := receive([]byte{0xff})
+	assert.True(t, errors.Is(err, ErrUnmarshal))
+
+	m := newMsg([]byte("hello"))
+	m.GenerateHash()
+	m.Payload = []byte("jello")
+	d, _ := Marshal(m)
+	_, err = receive(d)
+	assert.True(t, errors.Is(err, ErrHash))
+	var me *MsgError
+	assert.True(t, errors.As(err, &me))
+	assert.Equal(t, "bad", me.Target)
+	u, _ := uuid.FromBytes(m.GetHandle())
+	assert.Equal(t, u.String(), me.Handle)
+
+	stats := mb.Stats()["bad"]
+	assert.Equal(t, uint64(2), stats.Events[EventDropped])
+	assert.Equal(t, uint64(1), stats.Events[EventHashMismatch])
+	assert.Zero(t, stats.Events[EventReceived])
+
+	// replies to other requests are rejected
+	assert.Nil(t, mb.broker.(MsgBroker).RegisterBrokerHandler("other", func(data []byte) ([]byte, error) {
+		m := newMsg([]byte("hello"))
+		m.Flags |= MsgFlagsRespExpected
+		m.GenerateHash()
+		return Marshal(m)
+	}))
+	_, _, err = mb.SendAndWaitResponse([]byte("hello"), "other", 0)
+	assert.True(t, errors.Is(err, ErrRespMismatch))
+	assert.Equal(t, uint64(1), mb.Stats()["other"].Events[EventDropped])
+
+	// corrupted replies are rejected
+	assert.Nil(t, mb.broker.(MsgBroker).RegisterBrokerHandler("corrupt", func(data []byte) ([]byte, error) {
+		return []byte{0xff}, nil
+	}))
+	_, _, err = mb.SendAndWaitResponse([]byte("hello"), "corrupt", 0)
+	assert.True(t, errors.Is(err, ErrUnmarshal))
 }
 
 func BenchmarkNewMsg(b *testing.B) {
@@ -33,6 +117,8 @@ func BenchmarkGenVerifyHash(b *testing.B) {
 			panic(ErrNewMsg)
 		}
 		msg.GenerateHash()
-		msg.VerifyHash()
+		if err := msg.VerifyHash(); err != nil {
+			panic(err)
+		}
 	}
 }
@@ -2,13 +2,15 @@ package msgbus
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"sync"
 	"time"
 
 	"github.com/anuvu/cube/component"
 	"github.com/anuvu/cube/config"
-	"github.com/golang/protobuf/proto"
+	"github.com/anuvu/cube/metrics"
+	"github.com/anuvu/cube/tracing"
 	"github.com/satori/go.uuid"
 )
 
@@ -29,14 +31,46 @@ type Configuration struct {
 	// Listen port
 	MsgbusType string `json:"msgbus_type"`
 	MsgbusURI  string `json:"msgbus_uri"`
+	// Delivery enables the at least once delivery of the msgs sent
+	Delivery *DeliveryConfiguration `json:"delivery"`
 }
 
 // Msgbus is a message bus
+//
+// SendContext and SendAndWaitResponseContext propagate the trace of the span
+// carried by the context to the receiving handler. Handlers registered with
+// RegisterHandler receive a MsgContext per msg while RegisterMsgHandler is kept
+// for the handlers that only need the payload.
+//
+// Besides the point-to-point targets, msgs can be published on topics. Every
+// subscription matching the topic receives the msg except for the members of
+// a queue group, only one of which receives each msg.
+//
+// SendAsync and SendWithCallback do not block the caller. The responses of all
+// the asynchronous requests are received on a single inbox subscription.
+//
+// If the delivery is configured, Send stores the msg and returns, the msg is
+// then delivered until a handler acks it. The handlers registered with
+// RegisterHandler ack the msg by returning a nil error and nack it otherwise,
+// while the handlers registered with RegisterDeliveryHandler ack or nack it
+// explicitly. The nacked msgs are redelivered with a backoff until they
+// exhaust their attempts and go to the dead letter target.
 type Msgbus interface {
 	RegisterMsgHandler(target string, msgHandler func([]byte, bool) ([]byte, error)) error
+	RegisterHandler(target string, h Handler) error
+	RegisterDeliveryHandler(target string, h DeliveryHandler) error
 	UnregisterMsgHandler(target string) error
 	Send(data []byte, target string) error
+	SendContext(ctx context.Context, data []byte, target string) error
 	SendAndWaitResponse(data []byte, target string, timeout time.Duration) ([]byte, uuid.UUID, error)
+	SendAndWaitResponseContext(ctx context.Context, data []byte, target string, timeout time.Duration) ([]byte, uuid.UUID, error)
+	SendAsync(ctx context.Context, data []byte, target string, timeout time.Duration) (*Future, error)
+	SendWithCallback(ctx context.Context, data []byte, target string, timeout time.Duration, cb func([]byte, uuid.UUID, error)) error
+	Subscribe(pattern string, h Handler) (Subscription, error)
+	QueueSubscribe(pattern string, queue string, h Handler) (Subscription, error)
+	Publish(data []byte, topic string) error
+	PublishContext(ctx context.Context, data []byte, topic string) error
+	Stats() map[string]TargetStats
 }
 
 type msgbus struct {
@@ -44,18 +78,69 @@ type msgbus struct {
 	broker  Broker
 	running bool
 	lock    *sync.RWMutex
+	stats   *stats
+	tracer  tracing.Tracer
+	ctx     component.Context
+	inbox   inbox
+	store   MsgStore
+	deliver *deliverer
 }
 
-// New returns a new msgbus
-func New() Msgbus {
+// Option customizes a msgbus.
+type Option func(*msgbus)
+
+// WithMetrics records the statistics of the msgbus in the metrics registry too.
+func WithMetrics(r metrics.Registry) Option {
+	return func(mb *msgbus) {
+		mb.stats = newStats(r)
+	}
+}
+
+// WithTracer starts a span for every message sent and handled by the msgbus.
+func WithTracer(t tracing.Tracer) Option {
+	return func(mb *msgbus) {
+		mb.tracer = t
+	}
+}
+
+// New returns a new msgbus customized by the options. The options are ignored
+// when New is added to a group as the constructor of the msgbus.
+func New(opts ...Option) Msgbus {
+	return newMsgbus(opts...)
+}
+
+// NewWithTracer returns a new msgbus tracing the msgs with the tracer. It is
+// the constructor to add to a group along with the tracer of the tracing
+// package.
+func NewWithTracer(t tracing.Tracer) Msgbus {
+	return newMsgbus(WithTracer(t))
+}
+
+func newMsgbus(opts ...Option) *msgbus {
 	cfg := &Configuration{
 		BaseConfig: config.BaseConfig{ConfigKey: "msgbus"},
 	}
 
-	return &msgbus{config: cfg, running: false, lock: &sync.RWMutex{}}
+	mb := &msgbus{config: cfg, running: false, lock: &sync.RWMutex{}, stats: newStats(nil)}
+	for _, opt := range opts {
+		opt(mb)
+	}
+	return mb
+}
+
+// Stats returns the statistics of the messages per target.
+func (mb *msgbus) Stats() map[string]TargetStats {
+	if mb == nil || mb.stats == nil {
+		return map[string]TargetStats{}
+	}
+	return mb.stats.snapshot()
 }
 
 func (mb *msgbus) RegisterMsgHandler(target string, msgHandler func([]byte, bool) ([]byte, error)) error {
+	return mb.RegisterHandler(target, AdaptMsgHandler(msgHandler))
+}
+
+func (mb *msgbus) RegisterHandler(target string, h Handler) error {
 	if mb == nil {
 		return ErrNotInited
 	}
@@ -65,7 +150,194 @@ func (mb *msgbus) RegisterMsgHandler(target string, msgHandler func([]byte, bool
 	if target == "" {
 		return ErrBadSub
 	}
-	return mb.broker.RegisterMsgHandler(target, msgHandler)
+	if b, ok := mb.broker.(MsgBroker); ok {
+		return b.RegisterBrokerHandler(target, mb.receive(target, h))
+	}
+	return mb.broker.RegisterMsgHandler(target, mb.receivePayload(target, h))
+}
+
+func (mb *msgbus) RegisterDeliveryHandler(target string, h DeliveryHandler) error {
+	return mb.RegisterHandler(target, deliveryHandler(h))
+}
+
+func (mb *msgbus) Subscribe(pattern string, h Handler) (Subscription, error) {
+	return mb.QueueSubscribe(pattern, "", h)
+}
+
+func (mb *msgbus) QueueSubscribe(pattern string, queue string, h Handler) (Subscription, error) {
+	if mb == nil {
+		return nil, ErrNotInited
+	}
+	if mb.broker == nil {
+		return nil, ErrNotInited
+	}
+	if !ValidPattern(pattern) {
+		return nil, ErrBadTopic
+	}
+	return mb.broker.Subscribe(pattern, queue, mb.receive(pattern, h))
+}
+
+func (mb *msgbus) Publish(data []byte, topic string) error {
+	return mb.PublishContext(context.Background(), data, topic)
+}
+
+func (mb *msgbus) PublishContext(ctx context.Context, data []byte, topic string) error {
+	if mb == nil {
+		return ErrNotInited
+	}
+	if mb.broker == nil {
+		return ErrNotInited
+	}
+	if !ValidTopic(topic) {
+		return ErrBadTopic
+	}
+	if len(data) == 0 {
+		return ErrBadPayload
+	}
+
+	ctx, span := mb.startSpan(ctx, "msgbus publish "+topic)
+	defer span.End()
+
+	msg := newMsg(data)
+	msg.Match = topic
+	inject(ctx, msg)
+	msg.GenerateHash()
+	d, err := Marshal(msg)
+	if err != nil {
+		mb.stats.count(topic, EventFailed)
+		err = newMsgError(topic, msg, err)
+		span.SetError(err)
+		return err
+	}
+
+	if err := mb.broker.Publish(d, topic); err != nil {
+		mb.stats.count(topic, EventFailed)
+		span.SetError(err)
+		return err
+	}
+	mb.stats.count(topic, EventSent)
+	return nil
+}
+
+// receive returns the broker handler that unwraps the msgs of the target for
+// the handler and wraps its responses.
+func (mb *msgbus) receive(target string, h Handler) BrokerHandler {
+	return func(data []byte) ([]byte, error) {
+		msg, err := Unmarshal(data)
+		if err != nil {
+			return nil, mb.drop(target, nil, err)
+		}
+		if err := msg.VerifyHash(); err != nil {
+			mb.stats.count(target, EventHashMismatch)
+			return nil, mb.drop(target, msg, err)
+		}
+		mb.stats.count(target, EventReceived)
+
+		r, err := mb.handle(target, h, msg)
+		if msg.GetFlags()&MsgFlagsDurable == MsgFlagsDurable {
+			return mb.ack(target, msg, err)
+		}
+		var d []byte
+		if err == nil && r != nil {
+			d, err = mb.marshalReply(target, msg, r)
+		}
+		if msg.GetReplyTo() != "" && msg.GetFlags()&MsgFlagsRespExpected == MsgFlagsRespExpected {
+			mb.reply(target, msg, d, err)
+			return nil, err
+		}
+		return d, err
+	}
+}
+
+// receivePayload returns the payload handler of the target for the brokers that
+// are not MsgBrokers. The handler sees neither the handle, the trace nor the
+// deadline of the msgs.
+func (mb *msgbus) receivePayload(target string, h Handler) func([]byte, bool) ([]byte, error) {
+	return func(data []byte, respExpected bool) ([]byte, error) {
+		msg := &Msg{Payload: data}
+		if respExpected {
+			msg.Flags |= MsgFlagsRespExpected
+		}
+		mb.stats.count(target, EventReceived)
+		return mb.handle(target, h, msg)
+	}
+}
+
+// handle calls the handler with the msg and returns its response if one is
+// expected.
+func (mb *msgbus) handle(target string, h Handler, msg *Msg) ([]byte, error) {
+	mc, cancel := newMsgContext(mb.ctx, target, msg)
+	defer cancel()
+
+	// Resume the trace of the sender
+	if sc, err := tracing.ParseTraceparent(msg.GetTraceparent()); err == nil {
+		sc.State = msg.GetTracestate()
+		mc.ctx = tracing.ContextWithRemote(mc.ctx, sc)
+	}
+	var span *tracing.Span
+	mc.ctx, span = mb.startSpan(mc.ctx, "msgbus receive "+target)
+	defer span.End()
+
+	start := time.Now()
+	r, err := h(mc, msg.GetPayload())
+	mb.stats.observeHandler(target, time.Since(start))
+	if err != nil {
+		mb.stats.count(target, EventFailed)
+		span.SetError(err)
+		return nil, err
+	}
+
+	// if a response was expected for this msg, a response was expected of the callback!
+	if !mc.ResponseExpected() {
+		return nil, nil
+	}
+	if len(r) == 0 {
+		mb.stats.count(target, EventFailed)
+		span.SetError(ErrBadAppResp)
+		return nil, ErrBadAppResp
+	}
+	return r, nil
+}
+
+// marshalReply returns the marshaled reply of the msg carrying the response.
+func (mb *msgbus) marshalReply(target string, msg *Msg, r []byte) ([]byte, error) {
+	reply, err := msg.MakeReply(r)
+	if err != nil {
+		return nil, newMsgError(target, msg, err)
+	}
+	d, err := Marshal(reply)
+	if err != nil {
+		return nil, newMsgError(target, msg, err)
+	}
+	return d, nil
+}
+
+// drop counts and logs the msg of the target that could not be decoded or
+// verified. It returns the error for the broker.
+func (mb *msgbus) drop(target string, msg *Msg, err error) error {
+	mb.stats.count(target, EventDropped)
+	e := newMsgError(target, msg, err)
+	if mb.ctx != nil {
+		mb.ctx.Log().Error().Error(e).Str("target", target).Msg("dropped msg")
+	}
+	return e
+}
+
+// startSpan starts a span if the msgbus has a tracer, else it returns a nil
+// span which is a no-op.
+func (mb *msgbus) startSpan(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	if mb.tracer == nil {
+		return ctx, nil
+	}
+	return mb.tracer.Start(ctx, name)
+}
+
+// inject sets the trace context of the span carried by the context in the msg.
+func inject(ctx context.Context, msg *Msg) {
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		msg.Traceparent = sc.Traceparent()
+		msg.Tracestate = sc.State
+	}
 }
 
 func (mb *msgbus) UnregisterMsgHandler(target string) error {
@@ -82,6 +354,10 @@ func (mb *msgbus) UnregisterMsgHandler(target string) error {
 }
 
 func (mb *msgbus) Send(data []byte, target string) error {
+	return mb.SendContext(context.Background(), data, target)
+}
+
+func (mb *msgbus) SendContext(ctx context.Context, data []byte, target string) error {
 	if mb == nil {
 		return ErrNotInited
 	}
@@ -95,17 +371,51 @@ func (mb *msgbus) Send(data []byte, target string) error {
 		return ErrBadPayload
 	}
 
+	ctx, span := mb.startSpan(ctx, "msgbus send "+target)
+	defer span.End()
+
 	msg := newMsg(data)
+	if mb.deliver != nil {
+		mb.deliver.prepare(msg)
+	}
+	inject(ctx, msg)
 	msg.GenerateHash()
-	d, e := proto.Marshal(msg)
-	if e != nil {
-		panic("unable to marshal msg")
+	d, err := Marshal(msg)
+	if err != nil {
+		mb.stats.count(target, EventFailed)
+		err = newMsgError(target, msg, err)
+		span.SetError(err)
+		return err
 	}
 
-	return mb.broker.Send(d, target)
+	// the durable msgs are counted as sent once acked
+	if mb.deliver != nil {
+		if err := mb.deliver.send(target, msg, d); err != nil {
+			mb.stats.count(target, EventFailed)
+			err = newMsgError(target, msg, err)
+			span.SetError(err)
+			return err
+		}
+		return nil
+	}
+	if err := mb.broker.Send(d, target); err != nil {
+		mb.stats.count(target, EventFailed)
+		span.SetError(err)
+		return err
+	}
+	mb.stats.count(target, EventSent)
+	return nil
 }
 
 func (mb *msgbus) SendAndWaitResponse(data []byte, target string, timeout time.Duration) ([]byte, uuid.UUID, error) {
+	return mb.SendAndWaitResponseContext(context.Background(), data, target, timeout)
+}
+
+// SendAndWaitResponseContext sends a request to the target and waits for its
+// response. The wait is bounded by the timeout, if positive, and by the
+// deadline of the context, whichever comes first. The request fails with the
+// error of the context if the context is done before the response is received.
+func (mb *msgbus) SendAndWaitResponseContext(ctx context.Context, data []byte, target string, timeout time.Duration) ([]byte, uuid.UUID, error) {
 	if mb == nil {
 		return nil, uuid.Nil, ErrNotInited
 	}
@@ -118,31 +428,98 @@ func (mb *msgbus) SendAndWaitResponse(data []byte, target string, timeout time.D
 	if len(data) == 0 {
 		return nil, uuid.Nil, ErrBadPayload
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, uuid.Nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return nil, uuid.Nil, context.DeadlineExceeded
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+
+	ctx, span := mb.startSpan(ctx, "msgbus request "+target)
+	defer span.End()
 
 	msg := newMsg(data)
 	msg.Flags |= MsgFlagsRespExpected
+	// Round up so that sub-millisecond timeouts still bound the handler
+	msg.TimeoutMs = int64((timeout + time.Millisecond - 1) / time.Millisecond)
+	inject(ctx, msg)
 	msg.GenerateHash()
-	d, err := proto.Marshal(msg)
-	if err != nil {
-		panic("unable to marshal msg")
-	}
 	u, err := uuid.FromBytes(msg.GetHandle())
 	if err != nil {
-		panic("invalid handle")
+		return nil, uuid.Nil, &MsgError{Target: target, Err: ErrBadHandle}
 	}
-	r, err := mb.broker.SendAndWaitResponse(d, target, u.String(), timeout)
+	d, err := Marshal(msg)
 	if err != nil {
+		mb.stats.count(target, EventFailed)
+		err = newMsgError(target, msg, err)
+		span.SetError(err)
 		return nil, u, err
 	}
+	start := time.Now()
+	r, err := mb.waitResponse(ctx, d, target, u.String(), timeout)
+	if err == ErrTimeout || err == context.DeadlineExceeded {
+		mb.stats.count(target, EventTimedOut)
+		span.SetError(err)
+		return nil, u, err
+	} else if err != nil {
+		mb.stats.count(target, EventFailed)
+		span.SetError(err)
+		return nil, u, err
+	}
+	mb.stats.count(target, EventSent)
+	mb.stats.observeRoundTrip(target, time.Since(start))
 	m, err := Unmarshal(r)
+	if err == nil {
+		if err = m.VerifyHash(); err != nil {
+			mb.stats.count(target, EventHashMismatch)
+		} else if !bytes.Equal(m.GetHandle(), msg.GetHandle()) {
+			err = ErrRespMismatch
+		}
+	}
 	if err != nil {
-		panic("unable to unmarshal msg")
+		mb.stats.count(target, EventDropped)
+		err = newMsgError(target, msg, err)
+		span.SetError(err)
+		return nil, u, err
+	}
+	return m.GetPayload(), u, nil
+}
+
+// waitResponse sends the request through the broker and waits for its response
+// until the context is done. The broker keeps waiting in the background once
+// the context is done, until the response is received or the timeout expires.
+func (mb *msgbus) waitResponse(ctx context.Context, d []byte, target, handle string, timeout time.Duration) ([]byte, error) {
+	if ctx.Done() == nil {
+		return mb.broker.SendAndWaitResponse(d, target, handle, timeout)
 	}
-	m.VerifyHash()
-	if !bytes.Equal(m.GetHandle(), msg.GetHandle()) {
-		panic("request response mismatch")
+	type response struct {
+		d   []byte
+		err error
+	}
+	rch := make(chan response, 1)
+	go func() {
+		r, err := mb.broker.SendAndWaitResponse(d, target, handle, timeout)
+		rch <- response{r, err}
+	}()
+	select {
+	case r := <-rch:
+		// the timeout may have been cut to the deadline of the context, which
+		// may expire right after the broker gives up
+		if r.err == ErrTimeout {
+			if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+				return nil, context.DeadlineExceeded
+			}
+		}
+		return r.d, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	return m.GetPayload(), u, err
 }
 
 func (mb *msgbus) Config() config.Config {
@@ -154,22 +531,43 @@ func (mb *msgbus) Configure(ctx component.Context) error {
 }
 
 func (mb *msgbus) Start(ctx component.Context) error {
+	mb.ctx = ctx
 	// instantiate the broker based on configuration
 	b, err := NewBroker(mb.config)
 	if err != nil {
 		return err
 	}
 	mb.broker = b
+	if mb.config.Delivery != nil {
+		// the acks are replies of the msgbus of the handler
+		if _, ok := b.(MsgBroker); !ok {
+			return ErrUnSupp
+		}
+		if mb.deliver, err = newDeliverer(mb, mb.config.Delivery, mb.store); err != nil {
+			return err
+		}
+	}
 	err = mb.broker.Register()
-	if err == nil {
-		mb.lock.Lock()
-		defer mb.lock.Unlock()
-		mb.running = true
+	if err != nil {
+		return err
 	}
-	return err
+	if mb.deliver != nil {
+		if err := mb.deliver.start(); err != nil {
+			mb.broker.Unregister()
+			return err
+		}
+	}
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.running = true
+	return nil
 }
 
 func (mb *msgbus) Stop(ctx component.Context) error {
+	mb.closeInbox()
+	if mb.deliver != nil {
+		mb.deliver.stop()
+	}
 	err := mb.broker.Unregister()
 	mb.lock.Lock()
 	defer mb.lock.Unlock()
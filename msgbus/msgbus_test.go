@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 
@@ -186,3 +187,65 @@ func TestGroupIntegration(t *testing.T) {
 		assert.False(t, grp.IsHealthy())
 	})
 }
+
+// payloadBroker hides the RegisterBrokerHandler of the mock broker, like the
+// brokers that only deliver the payloads.
+type payloadBroker struct {
+	Broker
+}
+
+func init() {
+	RegisterFactory("payload", func(config *Configuration) (Broker, error) {
+		b, err := newMockBroker(config)
+		return payloadBroker{b}, err
+	})
+}
+
+func TestPayloadBroker(t *testing.T) {
+	mb := newMsgbus()
+	mb.config.MsgbusType = "payload"
+	assert.Nil(t, mb.Start(nil))
+	testAPISequence(t, mb)
+	assert.True(t, mb.Stats()["foo"].Events[EventReceived] > 0)
+	assert.Nil(t, mb.Stop(nil))
+
+	// the acks of the at least once delivery need a MsgBroker
+	mb = newMsgbus()
+	mb.config.MsgbusType = "payload"
+	mb.config.Delivery = &DeliveryConfiguration{}
+	assert.Equal(t, ErrUnSupp, mb.Start(nil))
+}
+
+func TestSendAndWaitResponseContext(t *testing.T) {
+	mb := newMsgbus()
+	mb.config.MsgbusType = "mock"
+	assert.Nil(t, mb.Start(nil))
+	defer mb.Stop(nil)
+	release := make(chan struct{})
+	defer close(release)
+	assert.Nil(t, mb.RegisterMsgHandler("slow", func(d []byte, respReqd bool) ([]byte, error) {
+		<-release
+		return d, nil
+	}))
+
+	// the deadline of the context bounds the wait
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := mb.SendAndWaitResponseContext(ctx, []byte("hello"), "slow", time.Minute)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, uint64(1), mb.Stats()["slow"].Events[EventTimedOut])
+
+	// cancelling the context ends the wait
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	_, _, err = mb.SendAndWaitResponseContext(ctx, []byte("hello"), "slow", 0)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// a done context fails the request right away
+	_, _, err = mb.SendAndWaitResponseContext(ctx, []byte("hello"), "slow", time.Minute)
+	assert.Equal(t, context.Canceled, err)
+}
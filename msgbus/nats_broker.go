@@ -0,0 +1,270 @@
+package msgbus
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// NatsType is the msgbus type of the NATS broker
+	NatsType = "nats"
+	// DefaultNatsDrainTimeout is the default time the NATS broker waits for
+	// the handlers of the msgs in flight when unregistering
+	DefaultNatsDrainTimeout = 5 * time.Second
+)
+
+type natsBroker struct {
+	servers      string
+	opts         []nats.Option
+	drainTimeout time.Duration
+	lock         sync.Mutex
+	conn         *nats.Conn
+	closed       chan struct{}
+	targets      map[string]*nats.Subscription
+}
+
+type natsSub struct {
+	broker  *natsBroker
+	sub     *nats.Subscription
+	pattern string
+	queue   string
+}
+
+// newNatsBroker returns a broker that connects to the NATS servers of the
+// comma separated URIs, e.g. "nats://10.0.0.1:4222,nats://10.0.0.2:4222". The
+// reconnect and drain_timeout query parameters of the first URI tune the
+// interval between the reconnection attempts and the time to drain the
+// subscriptions when unregistering.
+//
+// The targets are NATS subjects served by queue subscriptions named after the
+// target so that the brokers of several processes serving the same target
+// share its msgs. The topics of msgbus map to the NATS subjects as is.
+func newNatsBroker(config *Configuration) (Broker, error) {
+	b := &natsBroker{
+		drainTimeout: DefaultNatsDrainTimeout,
+		targets:      map[string]*nats.Subscription{},
+	}
+	b.opts = []nats.Option{nats.MaxReconnects(-1)}
+
+	servers := strings.Split(config.MsgbusURI, ",")
+	u, err := url.Parse(servers[0])
+	if err != nil || u.Scheme != NatsType {
+		return nil, ErrBadConfig
+	}
+	q := u.Query()
+	if v := q.Get("reconnect"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, ErrBadConfig
+		}
+		b.opts = append(b.opts, nats.ReconnectWait(d))
+	}
+	if v := q.Get("drain_timeout"); v != "" {
+		if b.drainTimeout, err = time.ParseDuration(v); err != nil || b.drainTimeout <= 0 {
+			return nil, ErrBadConfig
+		}
+	}
+	u.RawQuery = ""
+	servers[0] = u.String()
+	b.servers = strings.Join(servers, ",")
+	b.opts = append(b.opts, nats.DrainTimeout(b.drainTimeout))
+	return b, nil
+}
+
+func (b *natsBroker) Register() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn != nil {
+		return ErrDupConn
+	}
+
+	closed := make(chan struct{})
+	opts := append(b.opts, nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+	conn, err := nats.Connect(b.servers, opts...)
+	if err != nil {
+		return ErrConn
+	}
+	b.conn, b.closed = conn, closed
+	return nil
+}
+
+// Unregister drains the subscriptions so that the msgs in flight are handled,
+// and closes the connection.
+func (b *natsBroker) Unregister() error {
+	b.lock.Lock()
+	conn, closed := b.conn, b.closed
+	b.conn = nil
+	b.targets = map[string]*nats.Subscription{}
+	b.lock.Unlock()
+	if conn == nil {
+		return ErrBadBroker
+	}
+
+	if err := conn.Drain(); err != nil {
+		conn.Close()
+		return nil
+	}
+	select {
+	case <-closed:
+	case <-time.After(b.drainTimeout):
+		conn.Close()
+	}
+	return nil
+}
+
+func (b *natsBroker) RegisterMsgHandler(target string, msgHandler func([]byte, bool) ([]byte, error)) error {
+	return b.RegisterBrokerHandler(target, PayloadHandler(msgHandler))
+}
+
+func (b *natsBroker) RegisterBrokerHandler(target string, msgHandler BrokerHandler) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn == nil {
+		return ErrBadBroker
+	}
+	if b.targets[target] != nil {
+		return ErrDupSub
+	}
+
+	sub, err := b.conn.QueueSubscribe(target, target, natsHandler(msgHandler))
+	if err != nil {
+		return ErrSub
+	}
+	if err := b.conn.Flush(); err != nil {
+		sub.Unsubscribe()
+		return ErrSub
+	}
+	b.targets[target] = sub
+	return nil
+}
+
+func (b *natsBroker) UnregisterMsgHandler(target string) error {
+	b.lock.Lock()
+	sub, ok := b.targets[target]
+	delete(b.targets, target)
+	b.lock.Unlock()
+	if !ok {
+		return ErrSubNotFound
+	}
+	return sub.Drain()
+}
+
+func (b *natsBroker) Send(data []byte, target string) error {
+	conn, err := b.connection()
+	if err != nil {
+		return err
+	}
+	if err := conn.Publish(target, data); err != nil {
+		return ErrSend
+	}
+	return nil
+}
+
+// SendAndWaitResponse sends the request with the request/reply inboxes of NATS.
+// A handler error is replied as a msg carrying the error.
+func (b *natsBroker) SendAndWaitResponse(data []byte, target string, handle string, timeout time.Duration) ([]byte, error) {
+	conn, err := b.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	r, err := conn.RequestWithContext(ctx, target, data)
+	if err == context.DeadlineExceeded || err == nats.ErrTimeout {
+		return nil, ErrTimeout
+	} else if err == nats.ErrNoResponders {
+		return nil, ErrSubNotFound
+	} else if err != nil {
+		return nil, ErrSend
+	}
+	if m, err := Unmarshal(r.Data); err == nil {
+		if err := msgError(m); err != nil {
+			return nil, err
+		}
+	}
+	return r.Data, nil
+}
+
+func (b *natsBroker) Subscribe(pattern string, queue string, msgHandler BrokerHandler) (Subscription, error) {
+	conn, err := b.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	var sub *nats.Subscription
+	if queue == "" {
+		sub, err = conn.Subscribe(pattern, natsHandler(msgHandler))
+	} else {
+		sub, err = conn.QueueSubscribe(pattern, queue, natsHandler(msgHandler))
+	}
+	if err != nil {
+		return nil, ErrSub
+	}
+	if err := conn.Flush(); err != nil {
+		sub.Unsubscribe()
+		return nil, ErrSub
+	}
+	return &natsSub{broker: b, sub: sub, pattern: pattern, queue: queue}, nil
+}
+
+func (b *natsBroker) Publish(data []byte, topic string) error {
+	return b.Send(data, topic)
+}
+
+// connection returns the connection if the broker is registered.
+func (b *natsBroker) connection() (*nats.Conn, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn == nil {
+		return nil, ErrBadBroker
+	}
+	return b.conn, nil
+}
+
+func (sub *natsSub) Pattern() string {
+	return sub.pattern
+}
+
+func (sub *natsSub) Queue() string {
+	return sub.queue
+}
+
+func (sub *natsSub) Unsubscribe() error {
+	if err := sub.sub.Drain(); err != nil {
+		return ErrSubNotFound
+	}
+	return nil
+}
+
+// natsHandler returns the NATS msg handler calling the broker handler and
+// replying to the requests.
+func natsHandler(h BrokerHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		r, err := h(m.Data)
+		if m.Reply == "" {
+			return
+		}
+		if err != nil {
+			if r, err = Marshal(errorMsg(nil, err)); err != nil {
+				return
+			}
+		}
+		if r != nil {
+			m.Respond(r)
+		}
+	}
+}
+
+func init() {
+	RegisterFactory(NatsType, newNatsBroker)
+}
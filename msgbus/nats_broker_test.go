@@ -0,0 +1,24 @@
+package msgbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNatsBrokerConfig(t *testing.T) {
+	for _, uri := range []string{
+		"tcp://127.0.0.1:4222",
+		"nats://127.0.0.1:4222?reconnect=0s",
+		"nats://127.0.0.1:4222?drain_timeout=x",
+	} {
+		_, err := newNatsBroker(&Configuration{MsgbusType: NatsType, MsgbusURI: uri})
+		assert.Equal(t, ErrBadConfig, err, uri)
+	}
+
+	b, err := newNatsBroker(&Configuration{MsgbusType: NatsType, MsgbusURI: "nats://10.0.0.1:4222?drain_timeout=1s,nats://10.0.0.2:4222"})
+	assert.Nil(t, err)
+	assert.Equal(t, "nats://10.0.0.1:4222,nats://10.0.0.2:4222", b.(*natsBroker).servers)
+	assert.Equal(t, time.Second, b.(*natsBroker).drainTimeout)
+}
@@ -0,0 +1,134 @@
+//go:build nats
+// +build nats
+
+// The tests of the nats broker run against an embedded nats server, they are
+// built with "go test -tags nats" once the test imports are installed, as
+// .build/test.sh does.
+
+package msgbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func runNatsServer(port int) *server.Server {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = port
+	return natsserver.RunServer(&opts)
+}
+
+func newNatsMsgbus(t *testing.T, uri string) *msgbus {
+	mb := newMsgbus()
+	mb.config.MsgbusType = NatsType
+	mb.config.MsgbusURI = uri + "?reconnect=10ms&drain_timeout=1s"
+	assert.Nil(t, mb.Start(nil))
+	return mb
+}
+
+func TestNatsBroker(t *testing.T) {
+	s := runNatsServer(-1)
+	defer s.Shutdown()
+	client := newNatsMsgbus(t, s.ClientURL())
+	server := newNatsMsgbus(t, s.ClientURL())
+	defer client.Stop(nil)
+	defer server.Stop(nil)
+
+	rcvd := make(chan string, 10)
+	assert.Nil(t, server.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		rcvd <- string(data)
+		return data, nil
+	}))
+	assert.Nil(t, server.RegisterHandler("fail", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return nil, errors.New("no luck")
+	}))
+
+	assert.Nil(t, client.Send([]byte("hello"), "echo"))
+	assert.Equal(t, "hello", <-rcvd)
+
+	r, _, err := client.SendAndWaitResponse([]byte("sync"), "echo", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "sync", string(r))
+	<-rcvd
+
+	f, err := client.SendAsync(context.Background(), []byte("async"), "echo", time.Second)
+	assert.Nil(t, err)
+	r, err = f.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, "async", string(r))
+	<-rcvd
+
+	// the handler errors are returned to the requesters
+	_, _, err = client.SendAndWaitResponse([]byte("hello"), "fail", time.Second)
+	assert.EqualError(t, err, "no luck")
+	_, _, err = client.SendAndWaitResponse([]byte("hello"), "missing", 50*time.Millisecond)
+	assert.NotNil(t, err)
+
+	topics := make(chan string, 10)
+	_, err = server.Subscribe("events.*", func(ctx MsgContext, data []byte) ([]byte, error) {
+		topics <- ctx.Target()
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, client.Publish([]byte("hello"), "events.created"))
+	assert.Equal(t, "events.created", <-topics)
+}
+
+func TestNatsBrokerDrain(t *testing.T) {
+	s := runNatsServer(-1)
+	defer s.Shutdown()
+	b, err := newNatsBroker(&Configuration{MsgbusType: NatsType, MsgbusURI: s.ClientURL()})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Register())
+
+	started := make(chan struct{})
+	var handled int32
+	assert.Nil(t, b.(MsgBroker).RegisterBrokerHandler("slow", func(data []byte) ([]byte, error) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&handled, 1)
+		return nil, nil
+	}))
+	assert.Nil(t, b.Send([]byte("hello"), "slow"))
+	<-started
+
+	// unregistering waits for the msg in flight
+	assert.Nil(t, b.Unregister())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&handled))
+	assert.Equal(t, ErrBadBroker, b.Unregister())
+}
+
+func TestNatsBrokerReconnect(t *testing.T) {
+	s := runNatsServer(-1)
+	port := s.Addr().(*net.TCPAddr).Port
+	client := newNatsMsgbus(t, s.ClientURL())
+	server := newNatsMsgbus(t, s.ClientURL())
+	defer client.Stop(nil)
+	defer server.Stop(nil)
+	assert.Nil(t, server.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+
+	// the server restarts on the same port
+	s.Shutdown()
+	s = runNatsServer(port)
+	defer s.Shutdown()
+
+	// the subscriptions are restored once the brokers reconnect
+	var err error
+	for i := 0; i < 100; i++ {
+		if _, _, err = client.SendAndWaitResponse([]byte("hello"), "echo", 100*time.Millisecond); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+}
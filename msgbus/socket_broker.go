@@ -0,0 +1,467 @@
+package msgbus
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// SocketType is the msgbus type of the brokers connecting to a hub
+	SocketType = "socket"
+	// DefaultReconnect is the default interval between the attempts to
+	// reconnect to the hub
+	DefaultReconnect = 100 * time.Millisecond
+	// maxReconnect caps the interval between the attempts to reconnect
+	maxReconnect = 5 * time.Second
+)
+
+type socketBroker struct {
+	network   string
+	addr      string
+	heartbeat time.Duration
+	reconnect time.Duration
+	lock      sync.Mutex
+	conn      *frameConn
+	targets   map[string]BrokerHandler
+	subs      map[uuid.UUID]*socketSub
+	pending   map[uuid.UUID]chan *Msg
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type socketSub struct {
+	broker  *socketBroker
+	id      uuid.UUID
+	pattern string
+	queue   string
+	handler BrokerHandler
+}
+
+// newSocketBroker returns a broker that connects to a hub over TCP or a Unix
+// socket, e.g. "tcp://127.0.0.1:7400?heartbeat=1s&reconnect=100ms". The
+// broker reconnects to the hub if the connection fails or the hub goes silent
+// and restores its targets and subscriptions.
+func newSocketBroker(config *Configuration) (Broker, error) {
+	u, err := url.Parse(config.MsgbusURI)
+	if err != nil {
+		return nil, ErrBadConfig
+	}
+	b := &socketBroker{
+		reconnect: DefaultReconnect,
+		targets:   map[string]BrokerHandler{},
+		subs:      map[uuid.UUID]*socketSub{},
+		pending:   map[uuid.UUID]chan *Msg{},
+	}
+	if b.network, b.addr, err = socketAddr(u); err != nil {
+		return nil, err
+	}
+	if b.heartbeat, err = heartbeatParam(u); err != nil {
+		return nil, err
+	}
+	if v := u.Query().Get("reconnect"); v != "" {
+		if b.reconnect, err = time.ParseDuration(v); err != nil || b.reconnect <= 0 {
+			return nil, ErrBadConfig
+		}
+	}
+	return b, nil
+}
+
+// Register connects to the hub. The first connection must succeed, later
+// failures are retried in the background.
+func (b *socketBroker) Register() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.done != nil {
+		return ErrDupConn
+	}
+	conn, err := net.DialTimeout(b.network, b.addr, missedHeartbeats*b.heartbeat)
+	if err != nil {
+		return ErrConn
+	}
+	b.conn = newFrameConn(conn, b.heartbeat)
+	b.done = make(chan struct{})
+	b.wg.Add(2)
+	go b.run(b.conn)
+	go b.ping()
+	return nil
+}
+
+// Unregister disconnects from the hub.
+func (b *socketBroker) Unregister() error {
+	b.lock.Lock()
+	if b.done == nil {
+		b.lock.Unlock()
+		return ErrBadBroker
+	}
+	close(b.done)
+	if b.conn != nil {
+		b.conn.close()
+	}
+	b.lock.Unlock()
+	b.wg.Wait()
+
+	b.lock.Lock()
+	b.done = nil
+	b.lock.Unlock()
+	return nil
+}
+
+func (b *socketBroker) RegisterMsgHandler(target string, msgHandler func([]byte, bool) ([]byte, error)) error {
+	return b.RegisterBrokerHandler(target, PayloadHandler(msgHandler))
+}
+
+func (b *socketBroker) RegisterBrokerHandler(target string, msgHandler BrokerHandler) error {
+	b.lock.Lock()
+	if b.targets[target] != nil {
+		b.lock.Unlock()
+		return ErrDupSub
+	}
+	b.lock.Unlock()
+
+	if _, err := b.call(opRegister, &Msg{Match: target}, 0); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.targets[target] = msgHandler
+	b.lock.Unlock()
+	return nil
+}
+
+func (b *socketBroker) UnregisterMsgHandler(target string) error {
+	b.lock.Lock()
+	_, ok := b.targets[target]
+	delete(b.targets, target)
+	b.lock.Unlock()
+	if !ok {
+		return ErrSubNotFound
+	}
+	_, err := b.call(opUnregister, &Msg{Match: target}, 0)
+	return err
+}
+
+func (b *socketBroker) Send(data []byte, target string) error {
+	return b.write(opSend, &Msg{Match: target, Payload: data})
+}
+
+func (b *socketBroker) SendAndWaitResponse(data []byte, target string, handle string, timeout time.Duration) ([]byte, error) {
+	r, err := b.call(opRequest, &Msg{
+		Match:     target,
+		Handle:    handleBytes(handle),
+		Payload:   data,
+		TimeoutMs: int64((timeout + time.Millisecond - 1) / time.Millisecond),
+	}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetPayload(), nil
+}
+
+func (b *socketBroker) Subscribe(pattern string, queue string, msgHandler BrokerHandler) (Subscription, error) {
+	sub := &socketSub{broker: b, id: uuid.NewV4(), pattern: pattern, queue: queue, handler: msgHandler}
+	b.lock.Lock()
+	b.subs[sub.id] = sub
+	b.lock.Unlock()
+	if _, err := b.call(opSubscribe, sub.msg(), 0); err != nil {
+		b.lock.Lock()
+		delete(b.subs, sub.id)
+		b.lock.Unlock()
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (b *socketBroker) Publish(data []byte, topic string) error {
+	return b.write(opPublish, &Msg{Match: topic, Payload: data})
+}
+
+func (sub *socketSub) Pattern() string {
+	return sub.pattern
+}
+
+func (sub *socketSub) Queue() string {
+	return sub.queue
+}
+
+func (sub *socketSub) Unsubscribe() error {
+	b := sub.broker
+	b.lock.Lock()
+	_, ok := b.subs[sub.id]
+	delete(b.subs, sub.id)
+	b.lock.Unlock()
+	if !ok {
+		return ErrSubNotFound
+	}
+	_, err := b.call(opUnsubscribe, &Msg{Handle: sub.id.Bytes()}, 0)
+	if err == ErrConn {
+		// the subscription is gone with the connection anyway
+		return nil
+	}
+	return err
+}
+
+func (sub *socketSub) msg() *Msg {
+	return &Msg{Match: sub.pattern, ReplyTo: sub.queue, Handle: sub.id.Bytes()}
+}
+
+// write writes the frame to the hub if connected.
+func (b *socketBroker) write(op frameOp, msg *Msg) error {
+	b.lock.Lock()
+	c := b.conn
+	b.lock.Unlock()
+	if c == nil {
+		return ErrConn
+	}
+	if err := c.write(op, msg); err != nil {
+		return ErrConn
+	}
+	return nil
+}
+
+// call writes the frame and waits for the ack or the response correlated by
+// the handle of the msg. Acks are waited for up to the heartbeat timeout and
+// responses up to the timeout, if positive.
+func (b *socketBroker) call(op frameOp, msg *Msg, timeout time.Duration) (*Msg, error) {
+	if msg.Handle == nil {
+		msg.Handle = uuid.NewV4().Bytes()
+	}
+	id, err := uuid.FromBytes(msg.Handle)
+	if err != nil {
+		return nil, ErrBadHandle
+	}
+	if op != opRequest {
+		timeout = missedHeartbeats * b.heartbeat
+	}
+
+	ch := make(chan *Msg, 1)
+	b.lock.Lock()
+	b.pending[id] = ch
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		delete(b.pending, id)
+		b.lock.Unlock()
+	}()
+	if err := b.write(op, msg); err != nil {
+		return nil, err
+	}
+
+	// timeout chan, because timeout can be zero/disabled
+	var tch <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		tch = t.C
+	}
+	select {
+	case r := <-ch:
+		if r == nil {
+			return nil, ErrConn
+		}
+		if err := msgError(r); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case <-tch:
+		return nil, ErrTimeout
+	}
+}
+
+// run reads the frames from the hub and reconnects if the connection fails.
+func (b *socketBroker) run(c *frameConn) {
+	defer b.wg.Done()
+	for c != nil {
+		for {
+			f, err := c.read()
+			if err != nil {
+				break
+			}
+			b.handle(c, f)
+		}
+		c.close()
+		b.disconnected(c)
+		c = b.redial()
+	}
+}
+
+// disconnected fails the calls pending on the connection.
+func (b *socketBroker) disconnected(c *frameConn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn == c {
+		b.conn = nil
+	}
+	for id, ch := range b.pending {
+		delete(b.pending, id)
+		ch <- nil
+	}
+}
+
+// redial reconnects to the hub, backing off between the attempts, and restores
+// the targets and the subscriptions. It returns nil once the broker is
+// unregistered.
+func (b *socketBroker) redial() *frameConn {
+	backoff := b.reconnect
+	for {
+		select {
+		case <-b.done:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxReconnect {
+			backoff = maxReconnect
+		}
+
+		conn, err := net.DialTimeout(b.network, b.addr, missedHeartbeats*b.heartbeat)
+		if err != nil {
+			continue
+		}
+		c := newFrameConn(conn, b.heartbeat)
+		b.lock.Lock()
+		select {
+		case <-b.done:
+			b.lock.Unlock()
+			c.close()
+			return nil
+		default:
+		}
+		targets := []string{}
+		for target := range b.targets {
+			targets = append(targets, target)
+		}
+		subs := []*socketSub{}
+		for _, sub := range b.subs {
+			subs = append(subs, sub)
+		}
+		b.conn = c
+		b.wg.Add(1)
+		b.lock.Unlock()
+
+		// the acks are read by the caller
+		go b.restore(c, targets, subs)
+		return c
+	}
+}
+
+// restore registers the targets and the subscriptions on the new connection.
+// The hub keeps the targets of a half-open previous connection until it misses
+// its heartbeats, so the targets registered by another connection are retried
+// with a backoff. The connection is closed on any other failure so that the
+// broker redials the hub.
+func (b *socketBroker) restore(c *frameConn, targets []string, subs []*socketSub) {
+	defer b.wg.Done()
+	for _, sub := range subs {
+		if !b.restoring(c, func() bool { return b.subs[sub.id] != nil }) {
+			continue
+		}
+		if _, err := b.call(opSubscribe, sub.msg(), 0); err != nil {
+			c.close()
+			return
+		}
+	}
+
+	backoff := b.reconnect
+	for {
+		dups := []string{}
+		for _, target := range targets {
+			if !b.restoring(c, func() bool { return b.targets[target] != nil }) {
+				continue
+			}
+			_, err := b.call(opRegister, &Msg{Match: target}, 0)
+			if err == ErrDupSub {
+				dups = append(dups, target)
+			} else if err != nil {
+				c.close()
+				return
+			}
+		}
+		if targets = dups; len(targets) == 0 {
+			return
+		}
+		select {
+		case <-b.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxReconnect {
+			backoff = maxReconnect
+		}
+	}
+}
+
+// restoring returns true if the connection is still the connection of the
+// broker and the registration is still wanted.
+func (b *socketBroker) restoring(c *frameConn, wanted func() bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.conn == c && wanted()
+}
+
+// ping sends the heartbeats to the hub.
+func (b *socketBroker) ping() {
+	defer b.wg.Done()
+	t := time.NewTicker(b.heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-t.C:
+			b.write(opPing, &Msg{})
+		}
+	}
+}
+
+func (b *socketBroker) handle(c *frameConn, f *frame) {
+	msg := f.msg
+	switch f.op {
+	case opAck, opResponse:
+		id, _ := uuid.FromBytes(msg.GetHandle())
+		b.lock.Lock()
+		ch, ok := b.pending[id]
+		delete(b.pending, id)
+		b.lock.Unlock()
+		if ok {
+			ch <- msg
+		}
+	case opSend:
+		if h := b.handler(msg.GetMatch()); h != nil {
+			go h(msg.GetPayload())
+		}
+	case opRequest:
+		h := b.handler(msg.GetMatch())
+		go func() {
+			if h == nil {
+				c.write(opResponse, errorMsg(msg.GetHandle(), ErrSubNotFound))
+				return
+			}
+			r, err := h(msg.GetPayload())
+			if err != nil {
+				c.write(opResponse, errorMsg(msg.GetHandle(), err))
+				return
+			}
+			c.write(opResponse, &Msg{Handle: msg.GetHandle(), Payload: r})
+		}()
+	case opDeliver:
+		id, _ := uuid.FromBytes(msg.GetHandle())
+		b.lock.Lock()
+		sub := b.subs[id]
+		b.lock.Unlock()
+		if sub != nil {
+			go sub.handler(msg.GetPayload())
+		}
+	}
+}
+
+func (b *socketBroker) handler(target string) BrokerHandler {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.targets[target]
+}
+
+func init() {
+	RegisterFactory(SocketType, newSocketBroker)
+}
@@ -0,0 +1,200 @@
+package msgbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+var (
+	// ErrFrame malformed frame
+	ErrFrame = errors.New("broker: malformed frame")
+	// ErrFrameTooLarge the frame exceeds the maximum frame size
+	ErrFrameTooLarge = errors.New("broker: frame too large")
+)
+
+const (
+	// MaxFrameSize is the maximum size of a frame exchanged by the socket
+	// broker and the hub
+	MaxFrameSize = 16 << 20
+	// DefaultHeartbeat is the default interval of the heartbeats between the
+	// socket brokers and the hub. A peer is considered gone if nothing is
+	// received from it for three intervals.
+	DefaultHeartbeat = time.Second
+	// missedHeartbeats is the number of heartbeat intervals after which a
+	// silent peer is disconnected
+	missedHeartbeats = 3
+)
+
+// frameOp is the operation of a frame.
+type frameOp byte
+
+// The frames exchanged by the socket brokers and the hub. Every frame carries
+// a Msg whose fields are used as follows:
+//   - Match: the target, the topic or the subscription pattern
+//   - ReplyTo: the queue group of a subscription
+//   - Handle: the correlation handle of the requests, the acks and the
+//     subscription deliveries
+//   - Payload: the msg or the error of a failed request
+const (
+	opPing frameOp = iota + 1
+	opPong
+	opRegister
+	opUnregister
+	opSubscribe
+	opUnsubscribe
+	opAck
+	opSend
+	opRequest
+	opResponse
+	opPublish
+	opDeliver
+)
+
+// frame is a decoded frame.
+type frame struct {
+	op  frameOp
+	msg *Msg
+}
+
+// writeFrame writes the length prefixed frame: the big endian length of the
+// rest of the frame, the op and the marshaled msg.
+func writeFrame(w io.Writer, op frameOp, msg *Msg) error {
+	d, err := Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(d)+1 > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	buf := make([]byte, 5+len(d))
+	binary.BigEndian.PutUint32(buf, uint32(len(d)+1))
+	buf[4] = byte(op)
+	copy(buf[5:], d)
+	_, err = w.Write(buf)
+	return err
+}
+
+// readFrame reads a length prefixed frame.
+func readFrame(r *bufio.Reader) (*frame, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr)
+	if n == 0 {
+		return nil, ErrFrame
+	}
+	if n > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	msg, err := Unmarshal(buf[1:])
+	if err != nil {
+		return nil, ErrFrame
+	}
+	return &frame{op: frameOp(buf[0]), msg: msg}, nil
+}
+
+// frameConn is a connection exchanging frames. Writes are serialized and every
+// read or write extends the deadline of the connection so that a silent peer
+// is detected.
+type frameConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	lock      sync.Mutex
+	heartbeat time.Duration
+}
+
+func newFrameConn(conn net.Conn, heartbeat time.Duration) *frameConn {
+	return &frameConn{conn: conn, reader: bufio.NewReader(conn), heartbeat: heartbeat}
+}
+
+func (c *frameConn) write(op frameOp, msg *Msg) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(missedHeartbeats * c.heartbeat))
+	return writeFrame(c.conn, op, msg)
+}
+
+func (c *frameConn) read() (*frame, error) {
+	c.conn.SetReadDeadline(time.Now().Add(missedHeartbeats * c.heartbeat))
+	return readFrame(c.reader)
+}
+
+func (c *frameConn) close() error {
+	return c.conn.Close()
+}
+
+// socketAddr returns the network and the address of a tcp or a unix URI, e.g.
+// "tcp://127.0.0.1:7400" or "unix:///run/cube/msgbus.sock".
+func socketAddr(u *url.URL) (string, string, error) {
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return "", "", ErrBadConfig
+		}
+		return "tcp", u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", ErrBadConfig
+		}
+		return "unix", u.Path, nil
+	}
+	return "", "", ErrBadConfig
+}
+
+// heartbeatParam returns the heartbeat query parameter of the URI, or the
+// default heartbeat.
+func heartbeatParam(u *url.URL) (time.Duration, error) {
+	v := u.Query().Get("heartbeat")
+	if v == "" {
+		return DefaultHeartbeat, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, ErrBadConfig
+	}
+	return d, nil
+}
+
+// handleBytes returns the bytes of a handle, or a new handle if it is empty.
+func handleBytes(handle string) []byte {
+	if u, err := uuid.FromString(handle); err == nil {
+		return u.Bytes()
+	}
+	return uuid.NewV4().Bytes()
+}
+
+// errorMsg returns the msg carrying an error.
+func errorMsg(handle []byte, err error) *Msg {
+	return &Msg{Handle: handle, Flags: int32(MsgFlagsError), Payload: []byte(err.Error())}
+}
+
+// remoteErrors are the errors that are recognized when received from a peer.
+var remoteErrors = []error{ErrSubNotFound, ErrDupSub, ErrTimeout, ErrBadAppResp, ErrConn}
+
+// msgError returns the error carried by the msg, nil if none.
+func msgError(msg *Msg) error {
+	if msg.GetFlags()&MsgFlagsError != MsgFlagsError {
+		return nil
+	}
+	s := string(msg.GetPayload())
+	for _, e := range remoteErrors {
+		if e.Error() == s || strings.HasSuffix(s, ": "+e.Error()) {
+			return e
+		}
+	}
+	return errors.New(s)
+}
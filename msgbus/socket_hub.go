@@ -0,0 +1,410 @@
+package msgbus
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anuvu/cube/component"
+	"github.com/anuvu/cube/config"
+	"github.com/satori/go.uuid"
+)
+
+// HubConfiguration defines the configurable parameters of the hub
+type HubConfiguration struct {
+	config.BaseConfig
+	// Listen URI, e.g. "tcp://127.0.0.1:7400" or "unix:///run/cube/msgbus.sock"
+	Listen string `json:"listen"`
+	// Heartbeat interval expected from the connected brokers
+	Heartbeat string `json:"heartbeat"`
+}
+
+// Hub routes the msgs between the socket brokers connected to it over TCP or
+// a Unix socket. It is hosted as a component by one of the processes and the
+// others connect to it with the "socket" msgbus type.
+type Hub struct {
+	config    *HubConfiguration
+	ctx       component.Context
+	heartbeat time.Duration
+	network   string
+	addr      string
+	listener  net.Listener
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+	conns     map[*hubConn]bool
+	targets   map[string]*hubConn
+	subs      []*hubSub
+	queues    map[string]int
+	pending   map[uuid.UUID]*hubRequest
+}
+
+type hubConn struct {
+	*frameConn
+	subs map[uuid.UUID]*hubSub
+}
+
+type hubSub struct {
+	conn    *hubConn
+	id      []byte
+	pattern string
+	queue   string
+}
+
+// hubRequest is a request forwarded to the broker serving the target. The
+// response is correlated by the handle assigned by the hub and forwarded to
+// the origin with the handle of the origin.
+type hubRequest struct {
+	origin *hubConn
+	handle []byte
+	target *hubConn
+	timer  *time.Timer
+}
+
+// NewHub returns a new hub
+func NewHub() *Hub {
+	return &Hub{
+		config: &HubConfiguration{BaseConfig: config.BaseConfig{ConfigKey: "msgbus_hub"}},
+	}
+}
+
+// Config returns the configuration of the hub.
+func (h *Hub) Config() config.Config {
+	return h.config
+}
+
+// Configure validates the listen URI and the heartbeat of the hub.
+func (h *Hub) Configure(ctx component.Context) error {
+	u, err := url.Parse(h.config.Listen)
+	if err != nil {
+		return ErrBadConfig
+	}
+	if h.network, h.addr, err = socketAddr(u); err != nil {
+		return err
+	}
+	h.heartbeat = DefaultHeartbeat
+	if h.config.Heartbeat != "" {
+		if h.heartbeat, err = time.ParseDuration(h.config.Heartbeat); err != nil || h.heartbeat <= 0 {
+			return ErrBadConfig
+		}
+	}
+	return nil
+}
+
+// Start listens for the connections of the brokers.
+func (h *Hub) Start(ctx component.Context) error {
+	if h.network == "unix" {
+		// remove the socket left behind by a previous run, but nothing else
+		if fi, err := os.Lstat(h.addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(h.addr)
+		}
+	}
+	l, err := net.Listen(h.network, h.addr)
+	if err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.ctx = ctx
+	h.listener = l
+	h.conns = map[*hubConn]bool{}
+	h.targets = map[string]*hubConn{}
+	h.subs = nil
+	h.queues = map[string]int{}
+	h.pending = map[uuid.UUID]*hubRequest{}
+
+	h.wg.Add(1)
+	go h.accept(l)
+	return nil
+}
+
+// Stop closes the listener and all the connections.
+func (h *Hub) Stop(ctx component.Context) error {
+	h.lock.Lock()
+	l := h.listener
+	h.listener = nil
+	if l == nil {
+		h.lock.Unlock()
+		return nil
+	}
+	err := l.Close()
+	for c := range h.conns {
+		c.close()
+	}
+	h.lock.Unlock()
+	h.wg.Wait()
+	return err
+}
+
+// IsHealthy returns true if the hub is listening.
+func (h *Hub) IsHealthy(ctx component.Context) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.listener != nil
+}
+
+// Addr returns the URI the hub listens on, useful if the port is picked by
+// the system.
+func (h *Hub) Addr() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.listener == nil {
+		return ""
+	}
+	a := h.listener.Addr()
+	return a.Network() + "://" + a.String()
+}
+
+func (h *Hub) accept(l net.Listener) {
+	defer h.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := &hubConn{frameConn: newFrameConn(conn, h.heartbeat), subs: map[uuid.UUID]*hubSub{}}
+		h.lock.Lock()
+		if h.listener == nil {
+			// stopping
+			h.lock.Unlock()
+			conn.Close()
+			return
+		}
+		h.conns[c] = true
+		h.wg.Add(1)
+		h.lock.Unlock()
+		go h.serve(c)
+	}
+}
+
+// serve handles the frames of the connection until it fails or the peer goes
+// silent.
+func (h *Hub) serve(c *hubConn) {
+	defer h.wg.Done()
+	defer h.disconnect(c)
+	for {
+		f, err := c.read()
+		if err != nil {
+			return
+		}
+		if err := h.handle(c, f); err != nil {
+			if h.ctx != nil {
+				h.ctx.Log().Error().Error(err).Str("peer", c.conn.RemoteAddr().String()).Msg("dropping connection")
+			}
+			return
+		}
+	}
+}
+
+func (h *Hub) handle(c *hubConn, f *frame) error {
+	msg := f.msg
+	switch f.op {
+	case opPing:
+		return c.write(opPong, &Msg{})
+	case opRegister:
+		return c.write(opAck, h.register(c, msg))
+	case opUnregister:
+		return c.write(opAck, h.unregister(c, msg))
+	case opSubscribe:
+		return c.write(opAck, h.subscribe(c, msg))
+	case opUnsubscribe:
+		return c.write(opAck, h.unsubscribe(c, msg))
+	case opSend:
+		if t := h.target(msg.GetMatch()); t != nil {
+			t.write(opSend, &Msg{Match: msg.GetMatch(), Payload: msg.GetPayload()})
+		}
+		return nil
+	case opRequest:
+		h.request(c, msg)
+		return nil
+	case opResponse:
+		h.respond(msg)
+		return nil
+	case opPublish:
+		h.publish(msg)
+		return nil
+	}
+	return ErrFrame
+}
+
+func (h *Hub) register(c *hubConn, msg *Msg) *Msg {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if t, ok := h.targets[msg.GetMatch()]; ok && t != c {
+		return errorMsg(msg.GetHandle(), ErrDupSub)
+	}
+	h.targets[msg.GetMatch()] = c
+	return &Msg{Handle: msg.GetHandle()}
+}
+
+func (h *Hub) unregister(c *hubConn, msg *Msg) *Msg {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.targets[msg.GetMatch()] != c {
+		return errorMsg(msg.GetHandle(), ErrSubNotFound)
+	}
+	delete(h.targets, msg.GetMatch())
+	return &Msg{Handle: msg.GetHandle()}
+}
+
+func (h *Hub) subscribe(c *hubConn, msg *Msg) *Msg {
+	id, err := uuid.FromBytes(msg.GetHandle())
+	if err != nil || !ValidPattern(msg.GetMatch()) {
+		return errorMsg(msg.GetHandle(), ErrBadTopic)
+	}
+	sub := &hubSub{conn: c, id: msg.GetHandle(), pattern: msg.GetMatch(), queue: msg.GetReplyTo()}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := c.subs[id]; !ok {
+		c.subs[id] = sub
+		h.subs = append(h.subs, sub)
+	}
+	return &Msg{Handle: msg.GetHandle()}
+}
+
+func (h *Hub) unsubscribe(c *hubConn, msg *Msg) *Msg {
+	id, _ := uuid.FromBytes(msg.GetHandle())
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sub, ok := c.subs[id]
+	if !ok {
+		return errorMsg(msg.GetHandle(), ErrSubNotFound)
+	}
+	delete(c.subs, id)
+	h.removeSub(sub)
+	return &Msg{Handle: msg.GetHandle()}
+}
+
+func (h *Hub) target(target string) *hubConn {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.targets[target]
+}
+
+// request forwards the request to the broker serving the target under a
+// handle assigned by the hub.
+func (h *Hub) request(c *hubConn, msg *Msg) {
+	t := h.target(msg.GetMatch())
+	if t == nil {
+		c.write(opResponse, errorMsg(msg.GetHandle(), ErrSubNotFound))
+		return
+	}
+
+	id := uuid.NewV4()
+	r := &hubRequest{origin: c, handle: msg.GetHandle(), target: t}
+	h.lock.Lock()
+	h.pending[id] = r
+	if msg.GetTimeoutMs() > 0 {
+		// the origin gives up on the response after the timeout
+		r.timer = time.AfterFunc(time.Duration(msg.GetTimeoutMs())*time.Millisecond, func() {
+			h.lock.Lock()
+			delete(h.pending, id)
+			h.lock.Unlock()
+		})
+	}
+	h.lock.Unlock()
+
+	err := t.write(opRequest, &Msg{Match: msg.GetMatch(), Handle: id.Bytes(), Payload: msg.GetPayload(), TimeoutMs: msg.GetTimeoutMs()})
+	if err != nil {
+		h.fail(id, ErrConn)
+	}
+}
+
+// respond forwards the response to the origin of the request.
+func (h *Hub) respond(msg *Msg) {
+	id, _ := uuid.FromBytes(msg.GetHandle())
+	h.lock.Lock()
+	r, ok := h.pending[id]
+	delete(h.pending, id)
+	h.lock.Unlock()
+	if !ok {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.origin.write(opResponse, &Msg{Handle: r.handle, Flags: msg.GetFlags(), Payload: msg.GetPayload()})
+}
+
+// fail fails the pending request with the error.
+func (h *Hub) fail(id uuid.UUID, err error) {
+	h.lock.Lock()
+	r, ok := h.pending[id]
+	delete(h.pending, id)
+	h.lock.Unlock()
+	if !ok {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.origin.write(opResponse, errorMsg(r.handle, err))
+}
+
+// publish delivers the msg to every plain subscriber of the topic and to one
+// member of each queue group.
+func (h *Hub) publish(msg *Msg) {
+	topic := msg.GetMatch()
+	h.lock.Lock()
+	deliveries := []*hubSub{}
+	groups := map[string][]*hubSub{}
+	for _, sub := range h.subs {
+		if !MatchTopic(sub.pattern, topic) {
+			continue
+		}
+		if sub.queue == "" {
+			deliveries = append(deliveries, sub)
+		} else {
+			groups[sub.queue] = append(groups[sub.queue], sub)
+		}
+	}
+	for queue, members := range groups {
+		deliveries = append(deliveries, members[h.queues[queue]%len(members)])
+		h.queues[queue]++
+	}
+	h.lock.Unlock()
+
+	for _, sub := range deliveries {
+		sub.conn.write(opDeliver, &Msg{Match: topic, Handle: sub.id, Payload: msg.GetPayload()})
+	}
+}
+
+// disconnect removes the targets and the subscriptions of the connection and
+// fails the requests pending on it.
+func (h *Hub) disconnect(c *hubConn) {
+	c.close()
+	h.lock.Lock()
+	delete(h.conns, c)
+	for target, t := range h.targets {
+		if t == c {
+			delete(h.targets, target)
+		}
+	}
+	for _, sub := range c.subs {
+		h.removeSub(sub)
+	}
+	failed := []uuid.UUID{}
+	for id, r := range h.pending {
+		if r.target == c {
+			failed = append(failed, id)
+		} else if r.origin == c {
+			delete(h.pending, id)
+		}
+	}
+	h.lock.Unlock()
+
+	for _, id := range failed {
+		h.fail(id, ErrConn)
+	}
+}
+
+// removeSub removes the subscription, the lock must be held.
+func (h *Hub) removeSub(sub *hubSub) {
+	for i, s := range h.subs {
+		if s == sub {
+			h.subs = append(h.subs[:i], h.subs[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package msgbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anuvu/cube/component"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func startHub(t *testing.T, listen string) *Hub {
+	h := NewHub()
+	h.config.Listen = listen
+	h.config.Heartbeat = "50ms"
+	assert.Nil(t, h.Configure(nil))
+	assert.Nil(t, h.Start(nil))
+	return h
+}
+
+func newSocketMsgbus(t *testing.T, uri string) *msgbus {
+	mb := newMsgbus()
+	mb.config.MsgbusType = SocketType
+	mb.config.MsgbusURI = uri + "?heartbeat=50ms&reconnect=10ms"
+	assert.Nil(t, mb.Start(nil))
+	return mb
+}
+
+func TestFrames(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, writeFrame(buf, opSend, &Msg{Match: "target", Payload: []byte("hello")}))
+	assert.Nil(t, writeFrame(buf, opPing, &Msg{}))
+	r := bufio.NewReader(buf)
+	f, err := readFrame(r)
+	assert.Nil(t, err)
+	assert.Equal(t, opSend, f.op)
+	assert.Equal(t, "target", f.msg.GetMatch())
+	assert.Equal(t, []byte("hello"), f.msg.GetPayload())
+	f, err = readFrame(r)
+	assert.Nil(t, err)
+	assert.Equal(t, opPing, f.op)
+
+	// frames larger than the maximum frame size are rejected
+	_, err = readFrame(bufio.NewReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})))
+	assert.Equal(t, ErrFrameTooLarge, err)
+	_, err = readFrame(bufio.NewReader(bytes.NewReader([]byte{0, 0, 0, 0})))
+	assert.Equal(t, ErrFrame, err)
+}
+
+func TestSocketBrokerConfig(t *testing.T) {
+	for _, uri := range []string{
+		"inproc://test",
+		"tcp://",
+		"unix://",
+		"tcp://127.0.0.1:7400?heartbeat=0s",
+		"tcp://127.0.0.1:7400?reconnect=x",
+	} {
+		_, err := newSocketBroker(&Configuration{MsgbusType: SocketType, MsgbusURI: uri})
+		assert.Equal(t, ErrBadConfig, err, uri)
+	}
+
+	// the first connection must succeed
+	mb := newMsgbus()
+	mb.config.MsgbusType = SocketType
+	mb.config.MsgbusURI = "unix:///nonexistent/msgbus.sock"
+	assert.Equal(t, ErrConn, mb.Start(nil))
+}
+
+func testSocketRouting(t *testing.T, uri string) {
+	client := newSocketMsgbus(t, uri)
+	server := newSocketMsgbus(t, uri)
+	defer client.Stop(nil)
+	defer server.Stop(nil)
+
+	rcvd := make(chan string, 10)
+	assert.Nil(t, server.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		rcvd <- string(data)
+		return data, nil
+	}))
+	assert.Nil(t, server.RegisterHandler("fail", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return nil, errors.New("no luck")
+	}))
+	assert.Equal(t, ErrDupSub, client.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+
+	assert.Nil(t, client.Send([]byte("hello"), "echo"))
+	assert.Equal(t, "hello", <-rcvd)
+
+	r, _, err := client.SendAndWaitResponse([]byte("sync"), "echo", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "sync", string(r))
+	<-rcvd
+
+	f, err := client.SendAsync(context.Background(), []byte("async"), "echo", time.Second)
+	assert.Nil(t, err)
+	r, err = f.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, "async", string(r))
+	<-rcvd
+
+	// the errors are returned to the requesters
+	_, _, err = client.SendAndWaitResponse([]byte("hello"), "missing", time.Second)
+	assert.Equal(t, ErrSubNotFound, err)
+	_, _, err = client.SendAndWaitResponse([]byte("hello"), "fail", time.Second)
+	assert.EqualError(t, err, "no luck")
+
+	topics := make(chan string, 10)
+	_, err = server.Subscribe("events.*", func(ctx MsgContext, data []byte) ([]byte, error) {
+		topics <- ctx.Target()
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, client.Publish([]byte("hello"), "events.created"))
+	assert.Equal(t, "events.created", <-topics)
+
+	// the broker stays connected through the heartbeats
+	time.Sleep(200 * time.Millisecond)
+	r, _, err = client.SendAndWaitResponse([]byte("later"), "echo", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "later", string(r))
+}
+
+func TestSocketBrokerTCP(t *testing.T) {
+	h := startHub(t, "tcp://127.0.0.1:0")
+	defer h.Stop(nil)
+	testSocketRouting(t, h.Addr())
+}
+
+func TestSocketBrokerUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cube-msgbus")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	h := startHub(t, "unix://"+filepath.Join(dir, "msgbus.sock"))
+	testSocketRouting(t, h.Addr())
+
+	// the socket left behind is replaced, other files are not
+	h.listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.Nil(t, h.Stop(nil))
+	h = startHub(t, "unix://"+filepath.Join(dir, "msgbus.sock"))
+	defer h.Stop(nil)
+	file := filepath.Join(dir, "file")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("data"), 0600))
+	other := NewHub()
+	other.config.Listen = "unix://" + file
+	assert.Nil(t, other.Configure(nil))
+	assert.NotNil(t, other.Start(nil))
+	d, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(d))
+}
+
+func TestSocketBrokerReconnect(t *testing.T) {
+	h := startHub(t, "tcp://127.0.0.1:0")
+	addr := h.Addr()
+	client := newSocketMsgbus(t, addr)
+	server := newSocketMsgbus(t, addr)
+	defer client.Stop(nil)
+	defer server.Stop(nil)
+	assert.Nil(t, server.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+
+	// the hub restarts on the same address
+	assert.Nil(t, h.Stop(nil))
+	h = startHub(t, addr)
+	defer h.Stop(nil)
+
+	// the targets are restored once the brokers reconnect
+	var err error
+	for i := 0; i < 100; i++ {
+		if _, _, err = client.SendAndWaitResponse([]byte("hello"), "echo", time.Second); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+}
+
+func TestSocketBrokerRestoreHalfOpen(t *testing.T) {
+	h := startHub(t, "tcp://127.0.0.1:0")
+	defer h.Stop(nil)
+	client := newSocketMsgbus(t, h.Addr())
+	defer client.Stop(nil)
+	server := newMsgbus()
+	server.config.MsgbusType = SocketType
+	server.config.MsgbusURI = h.Addr() + "?heartbeat=50ms&reconnect=200ms"
+	assert.Nil(t, server.Start(nil))
+	defer server.Stop(nil)
+	assert.Nil(t, server.RegisterHandler("echo", func(ctx MsgContext, data []byte) ([]byte, error) {
+		return data, nil
+	}))
+
+	// the server loses its connection and another connection, standing for
+	// the half-open previous connection, holds the target until it goes away
+	b := server.broker.(*socketBroker)
+	b.lock.Lock()
+	b.conn.close()
+	b.lock.Unlock()
+	u, err := url.Parse(h.Addr())
+	assert.Nil(t, err)
+	network, addr, err := socketAddr(u)
+	assert.Nil(t, err)
+	conn, err := net.Dial(network, addr)
+	assert.Nil(t, err)
+	old := newFrameConn(conn, 50*time.Millisecond)
+	for {
+		assert.Nil(t, old.write(opRegister, &Msg{Match: "echo", Handle: uuid.NewV4().Bytes()}))
+		f, err := old.read()
+		assert.Nil(t, err)
+		if msgError(f.msg) == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 15; i++ {
+		assert.Nil(t, old.write(opPing, &Msg{}))
+		time.Sleep(20 * time.Millisecond)
+	}
+	old.close()
+
+	// the registration rejected on reconnect is retried
+	for i := 0; i < 200; i++ {
+		if _, _, err = client.SendAndWaitResponse([]byte("hello"), "echo", time.Second); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+}
+
+func TestHubComponent(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"msgbus_test", "-config.mem", `{"msgbus_hub": {"listen": "tcp://127.0.0.1:0"}}`}
+
+	grp := component.New("hub_test")
+	assert.Nil(t, grp.Add(NewHub))
+	assert.Nil(t, grp.Create())
+	assert.Nil(t, grp.Configure())
+	assert.Nil(t, grp.Start())
+	grp.Invoke(func(h *Hub) {
+		assert.True(t, grp.IsHealthy())
+		mb := newSocketMsgbus(t, h.Addr())
+		assert.Nil(t, mb.Stop(nil))
+	})
+	assert.Nil(t, grp.Stop())
+
+	// the listen URI is validated
+	os.Args = []string{"msgbus_test", "-config.mem", `{"msgbus_hub": {"listen": "udp://127.0.0.1:0"}}`}
+	grp = component.New("hub_test")
+	assert.Nil(t, grp.Add(NewHub))
+	assert.Nil(t, grp.Create())
+	assert.NotNil(t, grp.Configure())
+	assert.Equal(t, component.StateFailed, grp.State())
+}
@@ -0,0 +1,192 @@
+package msgbus
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anuvu/cube/metrics"
+)
+
+// Event is a message event counted in the statistics of a target.
+type Event string
+
+const (
+	// EventSent is counted for every message sent to the target.
+	EventSent Event = "sent"
+	// EventReceived is counted for every message handled for the target.
+	EventReceived Event = "received"
+	// EventFailed is counted for every message that failed to be sent or handled.
+	EventFailed Event = "failed"
+	// EventTimedOut is counted for every request that timed out waiting for
+	// the response.
+	EventTimedOut Event = "timed_out"
+	// EventHashMismatch is counted for every message that failed the hash
+	// verification.
+	EventHashMismatch Event = "hash_mismatch"
+	// EventDropped is counted for every message that was dropped because it
+	// could not be decoded or verified.
+	EventDropped Event = "dropped"
+	// EventRedelivered is counted for every message scheduled for redelivery
+	// after a failed delivery attempt.
+	EventRedelivered Event = "redelivered"
+	// EventDeadLettered is counted for every message that exhausted its
+	// delivery attempts.
+	EventDeadLettered Event = "dead_lettered"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of the latency
+// histograms.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Latency summarizes the observed durations. Buckets counts the durations per
+// bucket of LatencyBuckets, Buckets[i] counts the durations greater than the
+// previous bound and not greater than LatencyBuckets[i]. The last bucket counts
+// the durations greater than all the bounds.
+type Latency struct {
+	Count   uint64
+	Sum     time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Buckets []uint64
+}
+
+// Mean returns the mean of the observed durations.
+func (l Latency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Sum / time.Duration(l.Count)
+}
+
+// Quantile returns an upper bound of the q-quantile of the observed durations,
+// for example Quantile(0.99) bounds the 99th percentile. The bound is the upper
+// bound of the bucket of the quantile, or the maximum if that is lower.
+func (l Latency) Quantile(q float64) time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(l.Count)))
+	var n uint64
+	for i, c := range l.Buckets {
+		if n += c; n < rank {
+			continue
+		}
+		if i < len(LatencyBuckets) && LatencyBuckets[i] < l.Max {
+			return LatencyBuckets[i]
+		}
+		break
+	}
+	return l.Max
+}
+
+func (l *Latency) observe(d time.Duration) {
+	if l.Count == 0 || d < l.Min {
+		l.Min = d
+	}
+	if d > l.Max {
+		l.Max = d
+	}
+	l.Count++
+	l.Sum += d
+	if l.Buckets == nil {
+		l.Buckets = make([]uint64, len(LatencyBuckets)+1)
+	}
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	l.Buckets[i]++
+}
+
+// copy returns a copy of the latency not sharing the buckets.
+func (l Latency) copy() Latency {
+	l.Buckets = append([]uint64(nil), l.Buckets...)
+	return l
+}
+
+// TargetStats captures the statistics of the messages of a target.
+type TargetStats struct {
+	Events    map[Event]uint64
+	RoundTrip Latency
+	Handler   Latency
+}
+
+// stats records the statistics of the targets and mirrors them into the
+// metrics registry if one is provided.
+type stats struct {
+	lock      sync.Mutex
+	targets   map[string]*TargetStats
+	events    *metrics.Counter
+	roundTrip *metrics.Histogram
+	handler   *metrics.Histogram
+}
+
+func newStats(r metrics.Registry) *stats {
+	s := &stats{targets: map[string]*TargetStats{}}
+	if r != nil {
+		s.events = r.Counter("msgbus_events_total", "Number of the message events per target.", "target", "event")
+		buckets := make([]float64, len(LatencyBuckets))
+		for i, b := range LatencyBuckets {
+			buckets[i] = b.Seconds()
+		}
+		s.roundTrip = r.Histogram("msgbus_round_trip_seconds", "Round trip time of the requests per target.", buckets, "target")
+		s.handler = r.Histogram("msgbus_handler_seconds", "Execution time of the message handlers per target.", buckets, "target")
+	}
+	return s
+}
+
+func (s *stats) target(target string) *TargetStats {
+	ts, ok := s.targets[target]
+	if !ok {
+		ts = &TargetStats{Events: map[Event]uint64{}}
+		s.targets[target] = ts
+	}
+	return ts
+}
+
+func (s *stats) count(target string, e Event) {
+	s.lock.Lock()
+	s.target(target).Events[e]++
+	s.lock.Unlock()
+	if s.events != nil {
+		s.events.Inc(target, string(e))
+	}
+}
+
+func (s *stats) observeRoundTrip(target string, d time.Duration) {
+	s.lock.Lock()
+	s.target(target).RoundTrip.observe(d)
+	s.lock.Unlock()
+	if s.roundTrip != nil {
+		s.roundTrip.Observe(d.Seconds(), target)
+	}
+}
+
+func (s *stats) observeHandler(target string, d time.Duration) {
+	s.lock.Lock()
+	s.target(target).Handler.observe(d)
+	s.lock.Unlock()
+	if s.handler != nil {
+		s.handler.Observe(d.Seconds(), target)
+	}
+}
+
+// snapshot returns a copy of the statistics of all the targets.
+func (s *stats) snapshot() map[string]TargetStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snap := make(map[string]TargetStats, len(s.targets))
+	for target, ts := range s.targets {
+		events := make(map[Event]uint64, len(ts.Events))
+		for e, n := range ts.Events {
+			events[e] = n
+		}
+		snap[target] = TargetStats{Events: events, RoundTrip: ts.RoundTrip.copy(), Handler: ts.Handler.copy()}
+	}
+	return snap
+}
@@ -0,0 +1,89 @@
+package msgbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anuvu/cube/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	r := metrics.NewRegistry()
+	mb := newMsgbus(WithMetrics(r))
+	mb.config.MsgbusType = "mock"
+	assert.Nil(t, mb.Start(nil))
+	defer mb.Stop(nil)
+
+	testMsg := []byte("hello")
+	assert.Nil(t, mb.RegisterMsgHandler("echo", func(d []byte, respReqd bool) ([]byte, error) {
+		return d, nil
+	}))
+	assert.Nil(t, mb.RegisterMsgHandler("fail", func(d []byte, respReqd bool) ([]byte, error) {
+		return nil, errors.New("handler error")
+	}))
+
+	assert.Nil(t, mb.Send(testMsg, "echo"))
+	assert.Nil(t, mb.Send(testMsg, "fail"))
+	_, _, err := mb.SendAndWaitResponse(testMsg, "echo", time.Second)
+	assert.Nil(t, err)
+	_, _, err = mb.SendAndWaitResponse(testMsg, "nobody", time.Millisecond)
+	assert.Equal(t, ErrTimeout, err)
+
+	stats := mb.Stats()
+	echo := stats["echo"]
+	assert.Equal(t, map[Event]uint64{EventSent: 2, EventReceived: 2}, echo.Events)
+	assert.Equal(t, uint64(1), echo.RoundTrip.Count)
+	assert.True(t, echo.RoundTrip.Mean() > 0)
+	assert.Equal(t, uint64(2), echo.Handler.Count)
+	assert.True(t, echo.Handler.Min <= echo.Handler.Max)
+	assert.Equal(t, len(LatencyBuckets)+1, len(echo.Handler.Buckets))
+	assert.True(t, echo.Handler.Quantile(1) <= echo.Handler.Max)
+	assert.Equal(t, map[Event]uint64{EventSent: 1, EventReceived: 1, EventFailed: 1}, stats["fail"].Events)
+	assert.Equal(t, map[Event]uint64{EventTimedOut: 1}, stats["nobody"].Events)
+
+	// Snapshots are not affected by later messages
+	assert.Nil(t, mb.Send(testMsg, "echo"))
+	assert.Equal(t, uint64(2), echo.Events[EventSent])
+	assert.Equal(t, uint64(2), sum(echo.Handler.Buckets))
+
+	b := &bytes.Buffer{}
+	assert.Nil(t, r.WriteText(b))
+	assert.Contains(t, b.String(), `msgbus_events_total{target="echo",event="sent"} 3`)
+	assert.Contains(t, b.String(), `msgbus_events_total{target="nobody",event="timed_out"} 1`)
+	assert.Contains(t, b.String(), `msgbus_round_trip_seconds_count{target="echo"} 1`)
+	assert.Contains(t, b.String(), `msgbus_handler_seconds_count{target="fail"} 1`)
+
+	var nmb *msgbus
+	assert.Empty(t, nmb.Stats())
+}
+
+func TestLatency(t *testing.T) {
+	l := Latency{}
+	assert.Equal(t, time.Duration(0), l.Quantile(0.5))
+	for _, d := range []time.Duration{50 * time.Microsecond, 3 * time.Millisecond, 4 * time.Millisecond, 20 * time.Second} {
+		l.observe(d)
+	}
+	assert.Equal(t, uint64(4), sum(l.Buckets))
+	assert.Equal(t, uint64(1), l.Buckets[0])
+	assert.Equal(t, uint64(2), l.Buckets[5])
+	assert.Equal(t, uint64(1), l.Buckets[len(LatencyBuckets)])
+	assert.Equal(t, 100*time.Microsecond, l.Quantile(0.25))
+	assert.Equal(t, 5*time.Millisecond, l.Quantile(0.5))
+	assert.Equal(t, 20*time.Second, l.Quantile(0.99))
+
+	// the bound of a quantile does not exceed the maximum
+	l = Latency{}
+	l.observe(3 * time.Millisecond)
+	assert.Equal(t, 3*time.Millisecond, l.Quantile(0.5))
+}
+
+func sum(buckets []uint64) uint64 {
+	n := uint64(0)
+	for _, c := range buckets {
+		n += c
+	}
+	return n
+}
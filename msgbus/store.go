@@ -0,0 +1,192 @@
+package msgbus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// StoredMsg is a msg pending delivery.
+type StoredMsg struct {
+	// Handle of the msg
+	Handle uuid.UUID `json:"handle"`
+	// Target of the msg
+	Target string `json:"target"`
+	// Data is the marshaled msg
+	Data []byte `json:"data"`
+	// Attempts is the number of failed delivery attempts
+	Attempts int `json:"attempts"`
+	// NextAttempt is the time of the next delivery attempt
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// MsgStore keeps the msgs sent in the at least once delivery mode until they
+// are acked or dead lettered.
+type MsgStore interface {
+	// Put stores the msg, replacing the msg of the same handle
+	Put(msg *StoredMsg) error
+	// Delete removes the msg of the handle
+	Delete(handle uuid.UUID) error
+	// Load returns the stored msgs
+	Load() ([]*StoredMsg, error)
+}
+
+// memStore keeps the msgs in memory, they are lost when the process exits.
+type memStore struct {
+	lock sync.Mutex
+	msgs map[uuid.UUID]StoredMsg
+}
+
+// NewMemStore returns a store keeping the msgs in memory.
+func NewMemStore() MsgStore {
+	return &memStore{msgs: map[uuid.UUID]StoredMsg{}}
+}
+
+func (s *memStore) Put(msg *StoredMsg) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.msgs[msg.Handle] = *msg
+	return nil
+}
+
+func (s *memStore) Delete(handle uuid.UUID) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.msgs, handle)
+	return nil
+}
+
+func (s *memStore) Load() ([]*StoredMsg, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	msgs := make([]*StoredMsg, 0, len(s.msgs))
+	for _, m := range s.msgs {
+		m := m
+		msgs = append(msgs, &m)
+	}
+	return msgs, nil
+}
+
+const (
+	// msgFileExt is the extension of the files of the stored msgs
+	msgFileExt = ".msg"
+	// corruptFileExt is appended to the names of the files of the msgs that
+	// cannot be read, they are kept for inspection but no longer loaded
+	corruptFileExt = ".corrupt"
+)
+
+// FileStore keeps every msg in a file of a directory so that the msgs survive
+// the restarts of the process. It is meant for a single node, the directory
+// must not be shared by several msgbuses.
+type FileStore struct {
+	dir  string
+	lock sync.Mutex
+}
+
+// NewFileStore returns a store keeping the msgs in the directory, which is
+// created if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Put writes the msg to a temporary file renamed over the file of the msg, so
+// that the file is either the previous or the new version of the msg. The
+// directory is synced so that the rename survives a crash.
+func (s *FileStore) Put(msg *StoredMsg) error {
+	d, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := ioutil.TempFile(s.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(d); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(msg.Handle))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return s.syncDir()
+}
+
+// syncDir flushes the entries of the directory.
+func (s *FileStore) syncDir() error {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *FileStore) Delete(handle uuid.UUID) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := os.Remove(s.path(handle)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Load reads the msgs of the directory. The temporary files left behind by an
+// interrupted Put are removed and the unreadable files are renamed with the
+// corruptFileExt extension, the msgs are not loaded anymore but can still be
+// inspected.
+func (s *FileStore) Load() ([]*StoredMsg, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*StoredMsg{}
+	for _, fi := range files {
+		name := filepath.Join(s.dir, fi.Name())
+		if strings.HasPrefix(fi.Name(), "tmp-") {
+			os.Remove(name)
+			continue
+		}
+		if !strings.HasSuffix(fi.Name(), msgFileExt) {
+			continue
+		}
+		msg := &StoredMsg{}
+		d, err := ioutil.ReadFile(name)
+		if err == nil {
+			err = json.Unmarshal(d, msg)
+		}
+		if err != nil {
+			if err := os.Rename(name, name+corruptFileExt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (s *FileStore) path(handle uuid.UUID) string {
+	return filepath.Join(s.dir, handle.String()+msgFileExt)
+}
@@ -0,0 +1,68 @@
+package msgbus
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, s MsgStore) {
+	m := &StoredMsg{Handle: uuid.NewV4(), Target: "target", Data: []byte("data"), NextAttempt: time.Now().Round(0)}
+	assert.Nil(t, s.Put(m))
+	m.Attempts = 2
+	assert.Nil(t, s.Put(m))
+	msgs, err := s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, m.Handle, msgs[0].Handle)
+	assert.Equal(t, "target", msgs[0].Target)
+	assert.Equal(t, []byte("data"), msgs[0].Data)
+	assert.Equal(t, 2, msgs[0].Attempts)
+	assert.True(t, m.NextAttempt.Equal(msgs[0].NextAttempt))
+
+	assert.Nil(t, s.Delete(m.Handle))
+	assert.Nil(t, s.Delete(m.Handle))
+	msgs, err = s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(msgs))
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cube-msgbus")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	s, err := NewFileStore(filepath.Join(dir, "store"))
+	assert.Nil(t, err)
+	testStore(t, s)
+
+	// the leftovers of an interrupted put are removed and the corrupt msgs are
+	// quarantined
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "store", "tmp-1"), []byte("{"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "store", "bad"+msgFileExt), []byte("{"), 0600))
+	msgs, err := s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(msgs))
+	_, err = os.Stat(filepath.Join(dir, "store", "tmp-1"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "store", "bad"+msgFileExt))
+	assert.True(t, os.IsNotExist(err))
+	d, err := ioutil.ReadFile(filepath.Join(dir, "store", "bad"+msgFileExt+corruptFileExt))
+	assert.Nil(t, err)
+	assert.Equal(t, "{", string(d))
+
+	// the quarantined msgs are not loaded again
+	msgs, err = s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(msgs))
+	_, err = os.Stat(filepath.Join(dir, "store", "bad"+msgFileExt+corruptFileExt))
+	assert.Nil(t, err)
+}
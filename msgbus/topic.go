@@ -0,0 +1,76 @@
+package msgbus
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrBadTopic invalid topic or topic pattern
+	ErrBadTopic = errors.New("msgbus: bad topic")
+)
+
+const (
+	// TopicSeparator separates the tokens of a topic
+	TopicSeparator = "."
+	// WildcardToken matches exactly one token of a topic
+	WildcardToken = "*"
+	// TailWildcardToken matches one or more trailing tokens of a topic
+	TailWildcardToken = ">"
+)
+
+// Subscription is a subscription to the msgs published on the topics
+// matching a pattern.
+type Subscription interface {
+	// Pattern returns the topic pattern of the subscription
+	Pattern() string
+	// Queue returns the queue group of the subscription, empty if none
+	Queue() string
+	// Unsubscribe stops the delivery of the msgs to the subscription
+	Unsubscribe() error
+}
+
+// ValidTopic returns true if the topic can be published on. Topics are made of
+// non empty tokens separated by "." and do not contain wildcards.
+func ValidTopic(topic string) bool {
+	if topic == "" {
+		return false
+	}
+	for _, t := range strings.Split(topic, TopicSeparator) {
+		if t == "" || t == WildcardToken || t == TailWildcardToken {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidPattern returns true if the topic pattern can be subscribed to. The
+// "*" token matches any single token and the ">" token, allowed only as the
+// last token, matches all the remaining tokens.
+func ValidPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	tokens := strings.Split(pattern, TopicSeparator)
+	for i, t := range tokens {
+		if t == "" || (t == TailWildcardToken && i != len(tokens)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTopic returns true if the topic matches the pattern.
+func MatchTopic(pattern, topic string) bool {
+	pt := strings.Split(pattern, TopicSeparator)
+	tt := strings.Split(topic, TopicSeparator)
+	for i, p := range pt {
+		if p == TailWildcardToken {
+			return len(tt) > i
+		}
+		if i >= len(tt) || (p != WildcardToken && p != tt[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(tt)
+}
@@ -0,0 +1,103 @@
+package msgbus
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopics(t *testing.T) {
+	assert.True(t, ValidTopic("a.b.c"))
+	for _, topic := range []string{"", "a..b", ".a", "a.", "a.*", "a.>"} {
+		assert.False(t, ValidTopic(topic), topic)
+	}
+	assert.True(t, ValidPattern("a.*.c"))
+	assert.True(t, ValidPattern(">"))
+	for _, pattern := range []string{"", "a..b", "a.>.c"} {
+		assert.False(t, ValidPattern(pattern), pattern)
+	}
+
+	for _, m := range []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.b", "a.b.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"*.b", "a.b", true},
+		{"a.>", "a.b", true},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{">", "a", true},
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a.b.d", false},
+	} {
+		assert.Equal(t, m.match, MatchTopic(m.pattern, m.topic), "%s ~ %s", m.pattern, m.topic)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	mb := newMsgbus()
+	mb.config.MsgbusType = "mock"
+	assert.Nil(t, mb.Start(nil))
+	defer mb.Stop(nil)
+
+	lock := sync.Mutex{}
+	rcvd := map[string][]string{}
+	handler := func(name string) Handler {
+		return func(ctx MsgContext, data []byte) ([]byte, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			rcvd[name] = append(rcvd[name], ctx.Target())
+			return nil, nil
+		}
+	}
+
+	_, err := mb.Subscribe("a..b", handler("bad"))
+	assert.Equal(t, ErrBadTopic, err)
+	assert.Equal(t, ErrBadTopic, mb.Publish([]byte("hello"), "a.*"))
+
+	exact, err := mb.Subscribe("orders.created", handler("exact"))
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.created", exact.Pattern())
+	_, err = mb.Subscribe("orders.*", handler("wildcard"))
+	assert.Nil(t, err)
+	_, err = mb.Subscribe("orders.>", handler("tail"))
+	assert.Nil(t, err)
+	w1, err := mb.QueueSubscribe("orders.>", "workers", handler("worker1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "workers", w1.Queue())
+	_, err = mb.QueueSubscribe("orders.>", "workers", handler("worker2"))
+	assert.Nil(t, err)
+
+	// every subscriber gets the msgs while the workers take turns
+	assert.Nil(t, mb.Publish([]byte("hello"), "orders.created"))
+	assert.Nil(t, mb.Publish([]byte("hello"), "orders.updated"))
+	assert.Nil(t, mb.Publish([]byte("hello"), "orders.eu.created"))
+	assert.Nil(t, mb.Publish([]byte("hello"), "users.created"))
+
+	assert.Equal(t, []string{"orders.created"}, rcvd["exact"])
+	assert.Equal(t, []string{"orders.created", "orders.updated"}, rcvd["wildcard"])
+	assert.Equal(t, []string{"orders.created", "orders.updated", "orders.eu.created"}, rcvd["tail"])
+	workers := append(append([]string{}, rcvd["worker1"]...), rcvd["worker2"]...)
+	sort.Strings(workers)
+	assert.Equal(t, []string{"orders.created", "orders.eu.created", "orders.updated"}, workers)
+	assert.NotEmpty(t, rcvd["worker1"])
+	assert.NotEmpty(t, rcvd["worker2"])
+
+	// unsubscribed handlers no longer get the msgs
+	assert.Nil(t, exact.Unsubscribe())
+	assert.Equal(t, ErrSubNotFound, exact.Unsubscribe())
+	assert.Nil(t, mb.Publish([]byte("hello"), "orders.created"))
+	assert.Len(t, rcvd["exact"], 1)
+	assert.Len(t, rcvd["wildcard"], 3)
+
+	// msgs are counted per topic when published and per pattern when received
+	assert.Equal(t, uint64(2), mb.Stats()["orders.created"].Events[EventSent])
+	assert.Equal(t, uint64(3), mb.Stats()["orders.*"].Events[EventReceived])
+}
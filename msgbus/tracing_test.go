@@ -0,0 +1,90 @@
+package msgbus
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/cube/component"
+	"github.com/anuvu/cube/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracePropagation(t *testing.T) {
+	e := tracing.NewMemoryExporter()
+	tr := tracing.NewTracer(e)
+	mb := newMsgbus(WithTracer(tr))
+	mb.config.MsgbusType = "mock"
+	assert.Nil(t, mb.Start(nil))
+	defer mb.Stop(nil)
+
+	assert.Nil(t, mb.RegisterMsgHandler("echo", func(d []byte, respReqd bool) ([]byte, error) {
+		return d, nil
+	}))
+
+	ctx, parent := tr.Start(context.Background(), "caller")
+	assert.Nil(t, mb.SendContext(ctx, []byte("hello"), "echo"))
+	_, _, err := mb.SendAndWaitResponseContext(ctx, []byte("hello"), "echo", time.Second)
+	assert.Nil(t, err)
+	parent.End()
+
+	spans := map[string]tracing.SpanData{}
+	for _, s := range e.Spans() {
+		assert.Equal(t, parent.Context().TraceID.String(), s.TraceID)
+		if _, ok := spans[s.Name]; !ok {
+			spans[s.Name] = s
+		}
+	}
+	assert.Len(t, spans, 4)
+	assert.Equal(t, spans["caller"].SpanID, spans["msgbus send echo"].ParentID)
+	assert.Equal(t, spans["caller"].SpanID, spans["msgbus request echo"].ParentID)
+	assert.Contains(t, []string{spans["msgbus send echo"].SpanID, spans["msgbus request echo"].SpanID}, spans["msgbus receive echo"].ParentID)
+
+	// Traces are propagated without a tracer too
+	plain := newMsgbus()
+	plain.config.MsgbusType = "mock"
+	assert.Nil(t, plain.Start(nil))
+	defer plain.Stop(nil)
+	received := make(chan string, 1)
+	assert.Nil(t, plain.broker.(MsgBroker).RegisterBrokerHandler("raw", func(d []byte) ([]byte, error) {
+		m, err := Unmarshal(d)
+		assert.Nil(t, err)
+		received <- m.GetTraceparent()
+		return nil, nil
+	}))
+	assert.Nil(t, plain.SendContext(ctx, []byte("hello"), "raw"))
+	assert.Equal(t, parent.Context().Traceparent(), <-received)
+}
+
+func TestGroupTracer(t *testing.T) {
+	// Replace os.Args for test case
+	oldArgs := os.Args
+	os.Args = []string{"msgbus_test", "-config.mem", `{"msgbus": {"msgbus_type": "mock"}}`}
+	defer func() { os.Args = oldArgs }()
+
+	e := tracing.NewMemoryExporter()
+	grp := component.New("msgbus_test")
+	assert.Nil(t, grp.Add(tracing.NewWithExporter(e)))
+	assert.Nil(t, grp.Add(NewWithTracer))
+	assert.Nil(t, grp.Create())
+	assert.Nil(t, grp.Configure())
+	assert.Nil(t, grp.Start())
+	defer grp.Stop()
+
+	assert.Nil(t, grp.Invoke(func(mb Msgbus) {
+		assert.Nil(t, mb.RegisterMsgHandler("echo", func(d []byte, respReqd bool) ([]byte, error) {
+			return d, nil
+		}))
+		_, _, err := mb.SendAndWaitResponse([]byte("hello"), "echo", time.Second)
+		assert.Nil(t, err)
+	}))
+
+	spans := map[string]tracing.SpanData{}
+	for _, s := range e.Spans() {
+		spans[s.Name] = s
+	}
+	assert.Contains(t, spans, "msgbus request echo")
+	assert.Contains(t, spans, "msgbus receive echo")
+	assert.Equal(t, spans["msgbus request echo"].SpanID, spans["msgbus receive echo"].ParentID)
+}
@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/anuvu/cube/component"
+	"github.com/anuvu/cube/config"
+)
+
+// configuration defines the configurable parameters of the tracer
+type configuration struct {
+	config.BaseConfig
+	// File the spans are appended to as JSON lines, the spans are not exported
+	// if it is empty
+	File string `json:"file"`
+}
+
+type tracerComponent struct {
+	*tracer
+	config *configuration
+	file   *JSONFileExporter
+}
+
+// New creates the tracer of the process for adding to a component group.
+// Components start spans by injecting the Tracer into their constructors.
+//
+// The tracer starts a span for every lifecycle hook of the components in the
+// group hierarchy. The spans are exported to the JSON file configured under the
+// "tracing" key. The httptracing package traces the requests of the http server.
+func New(ctx component.Context, root component.Group) Tracer {
+	return newTracerComponent(root, nil)
+}
+
+// NewWithExporter returns the constructor of a tracer exporting the spans to
+// the exporter, ignoring the configured file.
+func NewWithExporter(e Exporter) func(ctx component.Context, root component.Group) Tracer {
+	return func(ctx component.Context, root component.Group) Tracer {
+		return newTracerComponent(root, e)
+	}
+}
+
+func newTracerComponent(root component.Group, e Exporter) *tracerComponent {
+	t := &tracerComponent{
+		tracer: &tracer{exporter: e},
+		config: &configuration{BaseConfig: config.BaseConfig{ConfigKey: "tracing"}},
+	}
+	if e != nil {
+		// The exporter does not need any configuration
+		t.config.ConfigKey = ""
+	}
+	root.SubscribeHooks(t.observeHook)
+	return t
+}
+
+func (t *tracerComponent) Config() config.Config {
+	return t.config
+}
+
+func (t *tracerComponent) Configure(ctx component.Context) error {
+	if t.config.File == "" {
+		return nil
+	}
+	f, err := NewJSONFileExporter(t.config.File)
+	if err != nil {
+		return err
+	}
+	t.file = f
+	t.setExporter(f)
+	return nil
+}
+
+func (t *tracerComponent) Stop(ctx component.Context) error {
+	if t.file == nil {
+		return nil
+	}
+	t.setExporter(nil)
+	return t.file.Close()
+}
+
+// observeHook records a span for the lifecycle hook of the component.
+func (t *tracer) observeHook(e component.HookEvent) {
+	s := t.startAt(context.Background(), string(e.Phase)+" "+e.Component, e.Start)
+	s.SetAttribute("group", e.Group)
+	s.SetAttribute("component", e.Component)
+	s.SetAttribute("phase", string(e.Phase))
+	s.SetError(e.Err)
+	s.endAt(e.Start.Add(e.Duration))
+}
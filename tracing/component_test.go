@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anuvu/cube/component"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type tracedCmp struct {
+	tracer Tracer
+}
+
+func newTracedCmp(t Tracer) *tracedCmp {
+	return &tracedCmp{t}
+}
+
+func (c *tracedCmp) Start(ctx component.Context) error {
+	_, span := c.tracer.Start(ctx.Ctx(), "work")
+	span.End()
+	return nil
+}
+
+func startGroup(tracer interface{}) component.Group {
+	root := component.New("root")
+	So(root.Add(tracer), ShouldBeNil)
+	So(root.Add(newTracedCmp), ShouldBeNil)
+	So(root.Create(), ShouldBeNil)
+	So(root.Configure(), ShouldBeNil)
+	So(root.Start(), ShouldBeNil)
+	return root
+}
+
+func TestTracingComponent(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	Convey("With a tracer exporting to memory", t, func() {
+		os.Args = []string{"tracing.test", "--config.mem", `{}`}
+		e := NewMemoryExporter()
+		root := startGroup(NewWithExporter(e))
+		defer root.Stop()
+
+		Convey("lifecycle hooks should be traced", func() {
+			spans := e.Spans()
+			So(spans, ShouldNotBeEmpty)
+			So(spans[len(spans)-2].Name, ShouldEqual, "work")
+			So(spans[len(spans)-1].Name, ShouldEqual, "start *tracing.tracedCmp")
+			So(spans[len(spans)-1].Attributes["group"], ShouldEqual, "root")
+		})
+	})
+
+	Convey("With a tracer exporting to a JSON file", t, func() {
+		dir, err := ioutil.TempDir("", "cube-tracing")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "spans.json")
+		os.Args = []string{"tracing.test", "--config.mem", fmt.Sprintf(`{"tracing": {"file": %q}}`, file)}
+		root := startGroup(New)
+		So(root.Stop(), ShouldBeNil)
+
+		f, err := os.Open(file)
+		So(err, ShouldBeNil)
+		defer f.Close()
+		names := []string{}
+		for sc := bufio.NewScanner(f); sc.Scan(); {
+			s := SpanData{}
+			So(json.Unmarshal(sc.Bytes(), &s), ShouldBeNil)
+			names = append(names, s.Name)
+		}
+		So(names, ShouldContain, "start *tracing.tracedCmp")
+		So(names, ShouldContain, "work")
+	})
+}
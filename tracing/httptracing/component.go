@@ -0,0 +1,46 @@
+// Package httptracing traces the requests served by the http server.
+package httptracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/anuvu/cube/component"
+	cubehttp "github.com/anuvu/cube/http"
+	"github.com/anuvu/cube/tracing"
+)
+
+// Instrumentation traces the requests served by the http server.
+type Instrumentation struct{}
+
+// New creates the instrumentation of the http server for adding to a component
+// group along with the tracer and the http server.
+//
+// A span is started for every request served by the http server, resuming the
+// trace of the W3C traceparent header of the request.
+func New(ctx component.Context, t tracing.Tracer, s cubehttp.Server) *Instrumentation {
+	s.Use(cubehttp.OrderRequestID, "tracing", Middleware(t))
+	return &Instrumentation{}
+}
+
+// Middleware starts a span for every http request, resuming the trace of the
+// W3C traceparent header of the request.
+func Middleware(t tracing.Tracer) cubehttp.Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if sc, err := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); err == nil {
+				sc.State = r.Header.Get(tracing.TracestateHeader)
+				ctx = tracing.ContextWithRemote(ctx, sc)
+			}
+			ctx, s := t.Start(ctx, "HTTP "+r.Method)
+			defer s.End()
+			s.SetAttribute("http.method", r.Method)
+			s.SetAttribute("http.path", r.URL.Path)
+
+			sw := cubehttp.NewStatusWriter(w)
+			h.ServeHTTP(sw, r.WithContext(ctx))
+			s.SetAttribute("http.status_code", strconv.Itoa(sw.Status()))
+		})
+	}
+}
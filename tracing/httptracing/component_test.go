@@ -0,0 +1,67 @@
+package httptracing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/anuvu/cube/component"
+	cubehttp "github.com/anuvu/cube/http"
+	"github.com/anuvu/cube/tracing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const port = 8994
+
+type tracedCmp struct{}
+
+func newTracedCmp(s cubehttp.Server, t tracing.Tracer) *tracedCmp {
+	s.HandleFunc("GET", "/traced", func(w http.ResponseWriter, r *http.Request) {
+		_, span := t.Start(r.Context(), "work")
+		span.End()
+		fmt.Fprint(w, tracing.SpanContextFromContext(r.Context()).Traceparent())
+	})
+	return &tracedCmp{}
+}
+
+func TestInstrumentation(t *testing.T) {
+	// Replace os.Args
+	oldArgs := os.Args
+	os.Args = []string{"httptracing.test", "--config.mem", fmt.Sprintf(`{"http": {"port": %d}}`, port)}
+	defer func() { os.Args = oldArgs }()
+
+	Convey("After we start a group tracing the http server", t, func() {
+		e := tracing.NewMemoryExporter()
+		root := component.New("root")
+		So(root.Add(cubehttp.New), ShouldBeNil)
+		So(root.Add(tracing.NewWithExporter(e)), ShouldBeNil)
+		So(root.Add(New), ShouldBeNil)
+		So(root.Add(newTracedCmp), ShouldBeNil)
+		So(root.Create(), ShouldBeNil)
+		So(root.Configure(), ShouldBeNil)
+		So(root.Start(), ShouldBeNil)
+		defer root.Stop()
+
+		Convey("http requests should resume the trace of the caller", func() {
+			e.Reset()
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/traced", port), nil)
+			req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			spans := e.Spans()
+			So(spans, ShouldHaveLength, 2)
+			So(spans[0].Name, ShouldEqual, "work")
+			So(spans[1].Name, ShouldEqual, "HTTP GET")
+			So(spans[1].TraceID, ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(spans[1].ParentID, ShouldEqual, "00f067aa0ba902b7")
+			So(spans[1].Attributes["http.status_code"], ShouldEqual, "200")
+			So(spans[0].ParentID, ShouldEqual, spans[1].SpanID)
+			So(string(b), ShouldEqual, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+spans[1].SpanID+"-01")
+		})
+	})
+}
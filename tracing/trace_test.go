@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTraceparent(t *testing.T) {
+	Convey("Traceparents should be formatted and parsed", t, func() {
+		tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		sc, err := ParseTraceparent(tp)
+		So(err, ShouldBeNil)
+		So(sc.TraceID.String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+		So(sc.SpanID.String(), ShouldEqual, "00f067aa0ba902b7")
+		So(sc.Sampled, ShouldBeTrue)
+		So(sc.Traceparent(), ShouldEqual, tp)
+
+		sc, err = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+		So(err, ShouldBeNil)
+		So(sc.Sampled, ShouldBeFalse)
+
+		// Future versions can append fields
+		_, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Invalid traceparents should be rejected", t, func() {
+		for _, tp := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+		} {
+			_, err := ParseTraceparent(tp)
+			So(err, ShouldEqual, ErrTraceparent)
+		}
+	})
+}
+
+func TestTracer(t *testing.T) {
+	Convey("With a tracer exporting to memory", t, func() {
+		e := NewMemoryExporter()
+		tr := NewTracer(e)
+
+		Convey("child spans should share the trace of the parent", func() {
+			ctx, parent := tr.Start(context.Background(), "parent")
+			So(SpanFromContext(ctx), ShouldEqual, parent)
+			_, child := tr.Start(ctx, "child")
+			child.SetAttribute("key", "value")
+			child.SetError(errors.New("failed"))
+			child.End()
+			child.End()
+			parent.End()
+
+			spans := e.Spans()
+			So(spans, ShouldHaveLength, 2)
+			So(spans[0].Name, ShouldEqual, "child")
+			So(spans[0].TraceID, ShouldEqual, spans[1].TraceID)
+			So(spans[0].ParentID, ShouldEqual, spans[1].SpanID)
+			So(spans[0].Attributes, ShouldResemble, map[string]string{"key": "value"})
+			So(spans[0].Error, ShouldEqual, "failed")
+			So(spans[1].ParentID, ShouldBeEmpty)
+			So(spans[1].End.Before(spans[1].Start), ShouldBeFalse)
+		})
+
+		Convey("spans should resume the remote traces", func() {
+			sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			sc.State = "vendor=1"
+			ctx := ContextWithRemote(context.Background(), sc)
+			So(SpanContextFromContext(ctx), ShouldResemble, sc)
+			_, s := tr.Start(ctx, "server")
+			So(s.Context().State, ShouldEqual, "vendor=1")
+			s.End()
+
+			spans := e.Spans()
+			So(spans[0].TraceID, ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(spans[0].ParentID, ShouldEqual, "00f067aa0ba902b7")
+		})
+
+		Convey("spans of the unsampled traces should not be exported", func() {
+			sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+			_, s := tr.Start(ContextWithRemote(context.Background(), sc), "server")
+			s.End()
+			So(e.Spans(), ShouldBeEmpty)
+		})
+
+		Convey("nil spans should be no-ops", func() {
+			var s *Span
+			s.SetAttribute("key", "value")
+			s.SetError(errors.New("failed"))
+			s.End()
+			So(s.Context().IsValid(), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// SpanData is the record of a finished span handed to the exporters.
+type SpanData struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Exporter exports the finished spans.
+type Exporter interface {
+	Export(s SpanData) error
+}
+
+// Tracer starts the spans.
+type Tracer interface {
+	// Start starts a span that is a child of the span carried by the context,
+	// or of the remote span context carried by the context. The returned
+	// context carries the new span.
+	Start(ctx context.Context, name string) (context.Context, *Span)
+}
+
+type tracer struct {
+	lock     sync.RWMutex
+	exporter Exporter
+}
+
+// NewTracer creates a tracer exporting the sampled spans to the exporter.
+func NewTracer(e Exporter) Tracer {
+	return &tracer{exporter: e}
+}
+
+func (t *tracer) setExporter(e Exporter) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.exporter = e
+}
+
+func (t *tracer) export(s SpanData) {
+	t.lock.RLock()
+	e := t.exporter
+	t.lock.RUnlock()
+	if e != nil {
+		e.Export(s)
+	}
+}
+
+func (t *tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := t.startAt(ctx, name, time.Now())
+	return ContextWithSpan(ctx, s), s
+}
+
+func (t *tracer) startAt(ctx context.Context, name string, start time.Time) *Span {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled, State: parent.State}
+	data := SpanData{Name: name, Start: start}
+	if parent.IsValid() {
+		data.ParentID = parent.SpanID.String()
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = true
+	}
+	data.TraceID = sc.TraceID.String()
+	data.SpanID = sc.SpanID.String()
+	return &Span{tracer: t, ctx: sc, data: data}
+}
+
+// Span is an operation in a trace. A span is exported when it ends. The methods
+// of a nil span are no-ops, so that the tracing can be optional.
+type Span struct {
+	tracer *tracer
+	ctx    SpanContext
+	lock   sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span context for propagating the span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]string{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError records the error of the operation, a nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End ends the span and exports it if it is sampled. Ending a span more than
+// once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.endAt(time.Now())
+}
+
+func (s *Span) endAt(end time.Time) {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	data := s.data
+	s.lock.Unlock()
+
+	if s.ctx.Sampled {
+		s.tracer.export(data)
+	}
+}
+
+// MemoryExporter keeps the exported spans in memory, for tests.
+type MemoryExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+// NewMemoryExporter creates an empty in-memory exporter.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// Export keeps the span.
+func (m *MemoryExporter) Export(s SpanData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spans = append(m.spans, s)
+	return nil
+}
+
+// Spans returns the exported spans in the order they ended.
+func (m *MemoryExporter) Spans() []SpanData {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]SpanData{}, m.spans...)
+}
+
+// Reset drops the exported spans.
+func (m *MemoryExporter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spans = nil
+}
+
+// JSONFileExporter appends the exported spans to a file as JSON lines, for
+// local debugging.
+type JSONFileExporter struct {
+	lock sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// NewJSONFileExporter creates an exporter appending to the file, the file is
+// created if it does not exist.
+func NewJSONFileExporter(path string) (*JSONFileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileExporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Export appends the span to the file.
+func (j *JSONFileExporter) Export(s SpanData) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.enc.Encode(s)
+}
+
+// Close closes the file.
+func (j *JSONFileExporter) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.f.Close()
+}